@@ -67,6 +67,15 @@ var g$ = function(el) {
         });
     }
 
+    /**
+     * Returns the CSRF token put in the head of the page by the server, or null if there is none.
+     * @returns {string|null}
+     */
+    function _csrfToken() {
+        var meta = document.querySelector('meta[name="csrf-token"]');
+        return meta ? meta.getAttribute("content") : null;
+    }
+
     /**
      * formObjChanged is an event handler that records that a control has changed in order to synchronize the control with
      * the server on the next request. Send the formObjChanged event to the control
@@ -679,6 +688,14 @@ var g$ = function(el) {
             // TODO: cache this, it will not change. No reason to do this over and over.
             return goradd.qs('form[data-grctl="form"]');
         },
+        /**
+         * csrfToken returns the CSRF token that the server put in the head of the page, or null if there is none.
+         * It is sent with the ajax calls and form posts made by goradd.
+         * @returns {string|null}
+         */
+        csrfToken: function () {
+            return _csrfToken();
+        },
         /**
          * matches returns true if the given element matches the css selector.
          * @param {string|object|HTMLElement}el
@@ -915,6 +932,16 @@ var g$ = function(el) {
 
             goradd.el('Goradd__Params').value = _getParamsValue(params);
 
+            // Send the CSRF token with the form, unless the form already has one
+            var token = _csrfToken();
+            if (token && !form.querySelector('input[name="Goradd__Csrf"]')) {
+                var input = document.createElement("input");
+                input.type = "hidden";
+                input.name = "Goradd__Csrf";
+                input.value = token;
+                form.appendChild(input);
+            }
+
             // trigger our own form submission so we can catch it
             gForm.trigger("submit");
         },
@@ -2645,6 +2672,10 @@ var g$ = function(el) {
             objRequest.open("POST", opts.url, true);
             objRequest.setRequestHeader("Method", "POST " + opts.url + " HTTP/1.1");
             objRequest.setRequestHeader("X-Requested-With", "xmlhttprequest");
+            var csrfToken = goradd.csrfToken();
+            if (csrfToken) {
+                objRequest.setRequestHeader("X-CSRF-Token", csrfToken);
+            }
             objRequest.timeout = goradd.ajaxTimeout;
 
             objRequest.onreadystatechange = function () {
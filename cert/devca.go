@@ -0,0 +1,217 @@
+//go:build !release
+
+package cert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/goradd/serve/config"
+	"github.com/goradd/serve/log"
+)
+
+const (
+	devCAName       = "goradd development CA"
+	devCACertFile   = "ca.crt"
+	devCAKeyFile    = "ca.key"
+	devLeafCertFile = "dev.crt"
+	devLeafKeyFile  = "dev.key"
+
+	devCALifetime   = 10 * 365 * 24 * time.Hour
+	devLeafLifetime = 365 * 24 * time.Hour
+	// devLeafRenewal is how long before expiration a development certificate is replaced.
+	devLeafRenewal = 30 * 24 * time.Hour
+)
+
+// DevPair returns the names of a certificate file and key file that can be used to serve HTTPS
+// during development, creating them if needed.
+//
+// The first time it is called, a local certificate authority is created in config.DevCertificateDir.
+// That authority then signs a certificate for localhost, the loopback addresses, and any names in
+// config.DevCertificateHosts. The files are reused on later runs, and the certificate is replaced
+// when it nears expiration or when the list of hosts changes.
+//
+// Browsers will warn about the certificate until you tell your operating system or browser to trust
+// the ca.crt file found in the same directory. You only need to do that once.
+//
+// DevPair is only available when building without the release tag. Never use these certificates
+// in production.
+func DevPair() (certFile, keyFile string, err error) {
+	dir := config.DevCertificateDir
+	if dir == "" {
+		if dir, err = os.UserCacheDir(); err != nil {
+			return
+		}
+		dir = filepath.Join(dir, "goradd", "devcert")
+	}
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return
+	}
+
+	caCert, caKey, err := loadOrCreateDevCA(dir)
+	if err != nil {
+		return
+	}
+
+	hosts := append([]string{"localhost", "127.0.0.1", "::1"}, config.DevCertificateHosts...)
+	certFile = filepath.Join(dir, devLeafCertFile)
+	keyFile = filepath.Join(dir, devLeafKeyFile)
+	if leafIsCurrent(certFile, keyFile, caCert, hosts) {
+		return
+	}
+
+	if err = createDevLeaf(certFile, keyFile, caCert, caKey, hosts); err != nil {
+		return "", "", err
+	}
+	log.Info(nil, logModule, "Created development certificate",
+		slog.String("certFile", certFile),
+		slog.Any("hosts", hosts))
+	return
+}
+
+// loadOrCreateDevCA returns the development certificate authority found in dir, creating a new one if
+// it does not exist or has expired.
+func loadOrCreateDevCA(dir string) (*x509.Certificate, crypto.Signer, error) {
+	certFile := filepath.Join(dir, devCACertFile)
+	keyFile := filepath.Join(dir, devCAKeyFile)
+
+	if c, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		leaf, err2 := x509.ParseCertificate(c.Certificate[0])
+		signer, ok := c.PrivateKey.(crypto.Signer)
+		if err2 == nil && ok && time.Now().Before(leaf.NotAfter) {
+			return leaf, signer, nil
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: devCAName, Organization: []string{devCAName}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(devCALifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = writePair(certFile, keyFile, der, key); err != nil {
+		return nil, nil, err
+	}
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Warn(nil, logModule, "Created a new development certificate authority. Tell your browser or operating system to trust it to avoid certificate warnings.",
+		slog.String("certFile", certFile))
+	return caCert, key, nil
+}
+
+// leafIsCurrent returns true if the certificate in certFile is signed by caCert, covers all the hosts,
+// and is not close to expiring.
+func leafIsCurrent(certFile, keyFile string, caCert *x509.Certificate, hosts []string) bool {
+	c, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return false
+	}
+	leaf, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		return false
+	}
+	if leaf.CheckSignatureFrom(caCert) != nil {
+		return false
+	}
+	if time.Now().Add(devLeafRenewal).After(leaf.NotAfter) {
+		return false
+	}
+	for _, h := range hosts {
+		if leaf.VerifyHostname(h) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// createDevLeaf creates a certificate for hosts signed by the development certificate authority.
+func createDevLeaf(certFile, keyFile string, caCert *x509.Certificate, caKey crypto.Signer, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hosts[0], Organization: []string{devCAName}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(devLeafLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else if !slices.Contains(tmpl.DNSNames, h) {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	if tmpl.NotAfter.After(caCert.NotAfter) {
+		tmpl.NotAfter = caCert.NotAfter
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	return writePair(certFile, keyFile, der, key)
+}
+
+// writePair writes a certificate and its private key to PEM files.
+func writePair(certFile, keyFile string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	// Write the key first, so that a certificate is never paired with the wrong key if this is interrupted.
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// newSerialNumber returns a random, positive certificate serial number.
+func newSerialNumber() (*big.Int, error) {
+	n, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, fmt.Errorf("could not create certificate serial number: %w", err)
+	}
+	return n.Add(n, big.NewInt(1)), nil
+}
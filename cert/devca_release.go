@@ -0,0 +1,10 @@
+//go:build release
+
+package cert
+
+import "errors"
+
+// DevPair is not available in the release build. Use a certificate from a real certificate authority.
+func DevPair() (certFile, keyFile string, err error) {
+	return "", "", errors.New("development certificates are not available in the release build")
+}
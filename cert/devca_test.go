@@ -0,0 +1,60 @@
+//go:build !release
+
+package cert
+
+import (
+	"crypto/x509"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goradd/serve/config"
+	"github.com/goradd/serve/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDevPair(t *testing.T) {
+	log.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)), "")
+	config.DevCertificateDir = t.TempDir()
+	defer func() {
+		config.DevCertificateDir = ""
+		config.DevCertificateHosts = nil
+	}()
+
+	certFile, keyFile, err := DevPair()
+	require.NoError(t, err)
+
+	m := NewManager()
+	require.NoError(t, m.AddPair(certFile, keyFile))
+	leaf := (*m.certs.Load())[0].Leaf
+	assert.NoError(t, leaf.VerifyHostname("localhost"))
+	assert.NoError(t, leaf.VerifyHostname("127.0.0.1"))
+
+	// The certificate chains to the CA
+	caPem, err := os.ReadFile(filepath.Join(config.DevCertificateDir, devCACertFile))
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(caPem))
+	_, err = leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: "localhost"})
+	assert.NoError(t, err)
+
+	// A second call reuses the files
+	stamp1, _ := fileStamp(certFile, keyFile)
+	_, _, err = DevPair()
+	require.NoError(t, err)
+	stamp2, _ := fileStamp(certFile, keyFile)
+	assert.Equal(t, stamp1, stamp2)
+
+	// A new host replaces the certificate, but not the CA
+	config.DevCertificateHosts = []string{"dev.example.com"}
+	_, _, err = DevPair()
+	require.NoError(t, err)
+	require.NoError(t, m.Reload())
+	leaf = (*m.certs.Load())[0].Leaf
+	assert.NoError(t, leaf.VerifyHostname("dev.example.com"))
+	_, err = leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: "dev.example.com"})
+	assert.NoError(t, err)
+}
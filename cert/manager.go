@@ -0,0 +1,222 @@
+// Package cert manages the TLS certificates used by the web server.
+package cert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goradd/serve/log"
+)
+
+const logModule = "cert"
+
+// WatchInterval is the default amount of time between checks of the certificate files for changes.
+var WatchInterval = time.Minute
+
+// Manager serves TLS certificates to a tls.Config through its GetCertificate hook,
+// and reloads the certificates when the files they came from change.
+//
+// A Manager can hold multiple certificate and key pairs, in which case the pair that matches
+// the server name requested by the browser (SNI) is served. The first pair added is served
+// if none of them match.
+//
+// When a certificate file changes, the new pair is loaded and validated before replacing
+// the old one. If the new pair is not valid, the error is logged and the old pair continues
+// to be served. Certificate authorities often write the certificate and key in two steps,
+// so a pair that fails to load is tried again when either file changes.
+type Manager struct {
+	mu    sync.Mutex
+	pairs []*pair
+	// certs is the current list of certificates, in the same order as pairs. It is replaced as a whole
+	// so that a handshake never sees a partially updated list.
+	certs atomic.Pointer[[]*tls.Certificate]
+
+	stop chan struct{}
+}
+
+// pair is a certificate file and its key file.
+type pair struct {
+	certFile string
+	keyFile  string
+	// stamp identifies the versions of the files last loaded, whether successfully or not.
+	stamp string
+}
+
+// NewManager returns a new certificate Manager with no certificates.
+func NewManager() *Manager {
+	m := new(Manager)
+	m.certs.Store(new([]*tls.Certificate))
+	return m
+}
+
+// AddPair loads the certificate in certFile and the private key in keyFile and adds them to the
+// certificates being served.
+//
+// An error is returned if the files do not exist, or do not hold a valid pair.
+func (m *Manager) AddPair(certFile, keyFile string) error {
+	stamp, err := fileStamp(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	c, err := loadPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pairs = append(m.pairs, &pair{certFile: certFile, keyFile: keyFile, stamp: stamp})
+	certs := append([]*tls.Certificate(nil), *m.certs.Load()...)
+	certs = append(certs, c)
+	m.certs.Store(&certs)
+	return nil
+}
+
+// Len returns the number of certificate pairs being served.
+func (m *Manager) Len() int {
+	return len(*m.certs.Load())
+}
+
+// GetCertificate returns the certificate that best matches the request described by hello.
+// It is suitable for use as the GetCertificate hook of a tls.Config.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certs := *m.certs.Load()
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates have been loaded")
+	}
+	if len(certs) > 1 {
+		for _, c := range certs {
+			if hello.SupportsCertificate(c) == nil {
+				return c, nil
+			}
+		}
+	}
+	return certs[0], nil
+}
+
+// TLSConfig returns a new tls.Config that gets its certificates from the Manager.
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: m.GetCertificate,
+	}
+}
+
+// Reload checks each certificate pair for changes on disk, and reloads the pairs that changed.
+//
+// Pairs that fail to load keep serving the previous certificate. The errors are logged and returned.
+func (m *Manager) Reload() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	certs := append([]*tls.Certificate(nil), *m.certs.Load()...)
+	var errs []error
+	var changed bool
+	for i, p := range m.pairs {
+		stamp, err := fileStamp(p.certFile, p.keyFile)
+		if err != nil || stamp == p.stamp {
+			// A missing file is likely part way through being replaced, so wait for it.
+			continue
+		}
+		p.stamp = stamp
+		c, err := loadPair(p.certFile, p.keyFile)
+		if err != nil {
+			log.Error(nil, logModule, "Could not reload certificate, continuing to use the previous one",
+				slog.String("certFile", p.certFile),
+				slog.String("keyFile", p.keyFile),
+				slog.Any("error", err))
+			errs = append(errs, err)
+			continue
+		}
+		log.Info(nil, logModule, "Reloaded certificate",
+			slog.String("certFile", p.certFile),
+			slog.Time("notAfter", c.Leaf.NotAfter))
+		certs[i] = c
+		changed = true
+	}
+	if changed {
+		m.certs.Store(&certs)
+	}
+	return errors.Join(errs...)
+}
+
+// Watch starts checking the certificate files for changes every interval, until StopWatching is called.
+// If interval is zero, WatchInterval will be used.
+//
+// Calling Watch on a Manager that is already watching has no effect.
+func (m *Manager) Watch(interval time.Duration) {
+	if interval == 0 {
+		interval = WatchInterval
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	m.stop = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = m.Reload()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopWatching stops checking the certificate files for changes.
+func (m *Manager) StopWatching() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
+	}
+}
+
+// loadPair loads and validates a certificate and key pair.
+func loadPair(certFile, keyFile string) (*tls.Certificate, error) {
+	c, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if c.Leaf == nil {
+		if c.Leaf, err = x509.ParseCertificate(c.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now()
+	if now.Before(c.Leaf.NotBefore) {
+		return nil, fmt.Errorf("certificate %s is not valid until %s", certFile, c.Leaf.NotBefore)
+	}
+	if now.After(c.Leaf.NotAfter) {
+		return nil, fmt.Errorf("certificate %s expired on %s", certFile, c.Leaf.NotAfter)
+	}
+	return &c, nil
+}
+
+// fileStamp returns a string that changes when either of the given files changes.
+func fileStamp(certFile, keyFile string) (string, error) {
+	ci, err := os.Stat(certFile)
+	if err != nil {
+		return "", err
+	}
+	ki, err := os.Stat(keyFile)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(ci.ModTime().UnixNano(), ci.Size(), ki.ModTime().UnixNano(), ki.Size()), nil
+}
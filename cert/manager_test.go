@@ -0,0 +1,115 @@
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goradd/serve/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestPair writes a self-signed certificate for host to dir and returns the file names.
+func writeTestPair(t *testing.T, dir string, host string, notAfter time.Time) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-2 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile = filepath.Join(dir, host+".crt")
+	keyFile = filepath.Join(dir, host+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return
+}
+
+func TestManager_Reload(t *testing.T) {
+	log.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)), "")
+	dir := t.TempDir()
+	certFile, keyFile := writeTestPair(t, dir, "example.com", time.Now().Add(time.Hour))
+
+	m := NewManager()
+	require.NoError(t, m.AddPair(certFile, keyFile))
+	c1, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	require.NoError(t, err)
+
+	// A bad file keeps the old certificate
+	require.NoError(t, os.WriteFile(certFile, []byte("bad"), 0600))
+	assert.Error(t, m.Reload())
+	c2, _ := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	assert.Same(t, c1, c2)
+
+	// An expired certificate keeps the old certificate
+	writeTestPair(t, dir, "example.com", time.Now().Add(-time.Hour))
+	assert.Error(t, m.Reload())
+	c2, _ = m.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	assert.Same(t, c1, c2)
+
+	// A good file replaces it
+	writeTestPair(t, dir, "example.com", time.Now().Add(2*time.Hour))
+	assert.NoError(t, m.Reload())
+	c2, _ = m.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	assert.NotSame(t, c1, c2)
+
+	// No change, no reload
+	assert.NoError(t, m.Reload())
+	c3, _ := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	assert.Same(t, c2, c3)
+}
+
+func TestManager_SNI(t *testing.T) {
+	dir := t.TempDir()
+	m := NewManager()
+	_, err := m.GetCertificate(&tls.ClientHelloInfo{})
+	assert.Error(t, err)
+
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		certFile, keyFile := writeTestPair(t, dir, host, time.Now().Add(time.Hour))
+		require.NoError(t, m.AddPair(certFile, keyFile))
+	}
+	assert.Equal(t, 2, m.Len())
+	assert.Error(t, m.AddPair(filepath.Join(dir, "missing.crt"), filepath.Join(dir, "missing.key")))
+
+	tests := []struct {
+		serverName string
+		want       string
+	}{
+		{"a.example.com", "a.example.com"},
+		{"b.example.com", "b.example.com"},
+		{"c.example.com", "a.example.com"},
+		{"", "a.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			hello := &tls.ClientHelloInfo{
+				ServerName:        tt.serverName,
+				SupportedVersions: []uint16{tls.VersionTLS13},
+				SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+			}
+			c, err := m.GetCertificate(hello)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, c.Leaf.Subject.CommonName)
+		})
+	}
+}
@@ -1,6 +1,8 @@
 // Package config contains configurable default values for various aspects of goradd.
 //
 // To change these values, set them from within an init() function in your application.
+// Most of them can also be set without a rebuild from a configuration file, environment variables
+// or command line flags by calling Load. See RegisterSetting.
 package config
 
 import "time"
@@ -22,6 +24,90 @@ var WriteTimeout = 20 * time.Second
 // It helps us detect whether the client has gone away so that we can then close the connection.
 var IdleTimeout = 180 * time.Second
 
+// ShutdownTimeout is the amount of time serve.Run gives the server to finish the requests in progress
+// when shutting down. Requests that have not finished by then are cut off.
+var ShutdownTimeout = 30 * time.Second
+
+// ShutdownDrainDelay is the amount of time the server keeps accepting new requests after a shutdown begins.
+// During this time the readiness endpoint fails, which gives load balancers a chance to notice
+// and stop sending requests before the server stops listening.
+var ShutdownDrainDelay = 0 * time.Second
+
+// UpgradeReadyTimeout is the amount of time serve.Runner.Upgrade waits for the new process to start serving
+// before it gives up, kills the new process, and keeps serving with the old one.
+var UpgradeReadyTimeout = 30 * time.Second
+
+// MaxConnectionsPerIP is the maximum number of connections the server will keep open from a single
+// remote IP address. Connections beyond that are closed as soon as they are accepted. Zero means there is no limit.
+//
+// If your server is behind a proxy, all connections will come from the proxy's address, so leave this at zero.
+var MaxConnectionsPerIP = 0
+
+// TrustedProxies are the addresses of the reverse proxies and load balancers in front of the server, as IP
+// addresses or CIDR ranges like "10.0.0.0/8". The forwarding headers of requests that come from these addresses
+// are used to find the address of the client, and the scheme, host and path prefix that the client used.
+// Add "unix" to trust the proxies that connect to the unix socket listeners. See http.WithTrustedProxies. Leave it empty if the server is not behind a proxy.
+var TrustedProxies []string
+
+// MaxRequestsInFlight is the maximum number of requests the server will serve at a time. Requests beyond that
+// wait in a queue. Zero means there is no limit. See http.WithLimits.
+var MaxRequestsInFlight = 0
+
+// MaxRequestQueue is the maximum number of requests that may wait for a turn when MaxRequestsInFlight
+// requests are being served. Requests beyond that are answered with a 503 Service Unavailable error.
+var MaxRequestQueue = 100
+
+// RequestQueueTimeout is the maximum amount of time a request may wait in the queue. Zero means the request
+// waits until the browser gives up.
+var RequestQueueTimeout = 5 * time.Second
+
+// MaxHeaderCount is the largest number of header lines a request may have, or zero for no limit. See http.WithHeaderValidator.
+// Raise it if your application is behind proxies or single sign-on gateways that add many headers.
+var MaxHeaderCount = 100
+
+// MaxHeaderSize is the largest total size in bytes of the header names and values of a request, or zero for no limit.
+// The server stops reading much larger headers before the validator sees them, see http.Server.MaxHeaderBytes.
+var MaxHeaderSize = 32 * 1024
+
+// MaxURLLength is the largest size in bytes of the url of a request, or zero for no limit.
+var MaxURLLength = 8 * 1024
+
+// AllowedMethods are the http methods the server answers. Requests that use other methods are answered
+// with 405 Method Not Allowed. Leave it empty to allow any method. The default rejects TRACE, CONNECT and the
+// WebDAV methods, so add them if your application serves them.
+var AllowedMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// AllowedHosts are the host names the server answers to, like "example.com" or "*.example.com" for its subdomains.
+// Requests with a different Host header are answered with 400 Bad Request. Leave it empty to allow any host.
+var AllowedHosts []string
+
+// FirewallMonitorOnly makes the request validator log the requests it would reject, but let them through.
+// Use it to try out new limits on live traffic.
+var FirewallMonitorOnly = false
+
+// AccessLogFormat turns on the access log of ServerBase, which records each request. It is one of
+// "common" or "combined", for the Apache log formats, or "json". Leave it blank to turn off the access log.
+// The lines are sent to the log package at the Info level, unless a writer is given to the access logger.
+var AccessLogFormat = ""
+
+// AccessLogExclude are the url path prefixes that are not recorded in the access log, like "/healthz" or "/assets/".
+var AccessLogExclude []string
+
+// TraceExporter turns on tracing in ServerBase.SetupTracing, and chooses where the spans are sent. It is "stdout",
+// to write them as JSON lines to the standard output, or "otlp", to send them to the OpenTelemetry collector
+// at TraceEndpoint. Leave it blank to turn off tracing.
+var TraceExporter = ""
+
+// TraceEndpoint is the url of the traces endpoint of the OpenTelemetry collector used by the "otlp" exporter.
+var TraceEndpoint = "http://localhost:4318/v1/traces"
+
+// TraceServiceName is the name of the application in the traces sent to the collector.
+var TraceServiceName = "goradd"
+
+// TraceSampleRatio is the fraction of new traces that are recorded, from 0 to 1.
+// Traces continued from another service follow the sampling decision of that service.
+var TraceSampleRatio = 1.0
+
 // AjaxTimeout is the amount of time in milliseconds that we direct the browser to wait until it determines that an ajax
 // call timed out. This would mean that the browser has lost the connection to the server. The goradd.js file put up a
 // dialog on the screen telling the user to refresh the page to re-establish the connection. This only happens in release
@@ -34,3 +120,48 @@ var CacheBusterPrefix = "gr."
 // DefaultPage is the default path of a url that ends with "/". Specify an empty string
 // to have no default pages.
 var DefaultPage = "index.html"
+
+// LogLevel is the lowest level of the messages the framework sends to the logger set with log.SetLogger.
+// It is one of "debug", "info", "warn" or "error". The handler of the logger can filter the messages further.
+var LogLevel = "debug"
+
+// Maintenance puts the application in maintenance mode, where requests for dynamic pages are answered with a
+// 503 Service Unavailable error. Static files and the health endpoints are still served.
+// Turn it on and off with config.Reload, so that the application does not have to be restarted.
+var Maintenance = false
+
+// HstsMaxAge is the default HSTS timeout in seconds given to ServerBase. Set it to -1 to turn off HSTS,
+// or 0 to clear the policy from the browsers.
+var HstsMaxAge int64 = 86400 // one day
+
+// HstsIncludeSubdomains is the default for whether the HSTS policy sent by ServerBase applies to subdomains.
+var HstsIncludeSubdomains = true
+
+// HstsPreload is the default for whether ServerBase asks for the domain to be put in the HSTS preload lists of the browsers.
+var HstsPreload = false
+
+// SecurityHeaders turns on the security headers of ServerBase, like Content-Security-Policy and X-Frame-Options.
+// See http.DefaultSecurityHeaders.
+var SecurityHeaders = true
+
+// ContentSecurityPolicy replaces the Content-Security-Policy of http.DefaultSecurityHeaders given to ServerBase,
+// if it is not blank.
+var ContentSecurityPolicy = ""
+
+// CSPReportOnly sends the Content-Security-Policy of ServerBase in report-only mode, so that the browser reports
+// violations of the policy without blocking anything.
+var CSPReportOnly = false
+
+// CSPNonce adds a random nonce to the script-src and style-src directives of the Content-Security-Policy
+// of each html page, and to the <script> and <style> tags of the page, so that the inline scripts and styles
+// of the page can run under a policy that does not allow 'unsafe-inline'. Since the nonce is added to
+// every tag, it does not keep scripts injected into the page from running. See http.WithCSPNonce.
+var CSPNonce = false
+
+// CSPNonceTags adds the nonce of CSPNonce to the <script> and <style> tags of each html page. Turn it off if the
+// templates write the nonces of their tags themselves, so that injected scripts are blocked.
+var CSPNonceTags = true
+
+// CSRF turns on the protection against cross-site request forgery of ServerBase. Forms and ajax calls must then send
+// the token made by http.CSRFToken. See http.WithCSRF.
+var CSRF = false
@@ -0,0 +1,13 @@
+package config
+
+// HTTP2MaxConcurrentStreams is the number of streams each client may have open at a time on a cleartext
+// HTTP/2 (h2c) connection. Zero uses the HTTP/2 server's default, which is currently 250.
+var HTTP2MaxConcurrentStreams uint32 = 0
+
+// HTTP2MaxReadFrameSize is the largest HTTP/2 frame the server is willing to read on a cleartext
+// HTTP/2 (h2c) connection. Valid values are between 16k and 16M. Zero uses the HTTP/2 server's default.
+var HTTP2MaxReadFrameSize uint32 = 0
+
+// HTTP2MaxUpgradeBodySize is the largest request body the server will accept on the request that upgrades
+// an HTTP/1.1 connection to cleartext HTTP/2 (h2c). That request is read entirely into memory before it is handled.
+var HTTP2MaxUpgradeBodySize int64 = 10 << 20
@@ -0,0 +1,732 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is put in front of the environment variable names that Load reads settings from.
+// For example, ReadTimeout is read from GORADD_READ_TIMEOUT.
+var EnvPrefix = "GORADD_"
+
+// These are the sources a setting can get its value from, from lowest to highest precedence.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// Setting is a configuration variable that can be loaded from a file, the environment or the command line.
+//
+// The variable must be a pointer to a string, bool, int, int64, uint32, float64, time.Duration,
+// fs.FileMode or []string.
+type Setting struct {
+	name     string
+	usage    string
+	ptr      any
+	hasRange bool
+	min, max float64
+	validate func(v any) error
+
+	restartOnly bool
+	defVal      any
+	hasDef      bool
+
+	source  string
+	origin  string // the file, variable or flag the value came from
+	flagVal *string
+}
+
+// Change describes a setting whose value was changed by Load or Reload.
+// Old and New have the type of the configuration variable, like time.Duration.
+type Change struct {
+	Name string
+	Old  any
+	New  any
+}
+
+// ErrRestartRequired is returned by Reload when a setting marked with RestartOnly would change.
+var ErrRestartRequired = errors.New("the setting cannot be changed without restarting the application")
+
+var settingsMu sync.Mutex
+
+// valuesMu is held for writing while Load and Reload assign the configuration variables, so that Value
+// can read them from other goroutines.
+var valuesMu sync.RWMutex
+var settings []*Setting
+var subscribers []func([]Change)
+var loadedFile string
+
+// RegisterSetting makes the variable ptr points to loadable by Load under the given name.
+// name should be the name of the variable, as in "ReadTimeout".
+//
+// The current value of the variable is its default. Applications can register their own settings
+// from an init() function. It will panic if ptr is not one of the supported types, or if
+// the name is already registered.
+func RegisterSetting(name string, usage string, ptr any) *Setting {
+	switch ptr.(type) {
+	case *string, *bool, *int, *int64, *uint32, *float64, *time.Duration, *fs.FileMode, *[]string:
+	default:
+		panic(fmt.Sprintf("setting %s has unsupported type %T", name, ptr))
+	}
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	if findSetting(name) != nil {
+		panic(fmt.Sprintf("setting %s is already registered", name))
+	}
+	s := &Setting{name: name, usage: usage, ptr: ptr, source: SourceDefault}
+	settings = append(settings, s)
+	return s
+}
+
+// Range limits a numeric or duration setting to values from min to max inclusive.
+// Durations are compared in seconds. It returns s so that calls can be chained.
+func (s *Setting) Range(min, max float64) *Setting {
+	s.hasRange = true
+	s.min, s.max = min, max
+	return s
+}
+
+// Validate adds a function that checks a new value of the setting before it is assigned.
+// It returns s so that calls can be chained.
+func (s *Setting) Validate(f func(v any) error) *Setting {
+	s.validate = f
+	return s
+}
+
+// RestartOnly marks a setting that is only read when the application starts, so that Reload
+// will refuse to change it. It returns s so that calls can be chained.
+//
+// A setting that is not marked must only be read with Value, or passed on by a function registered with OnChange,
+// since Reload may assign it while requests are being served. Mark any setting whose variable is read directly.
+func (s *Setting) RestartOnly() *Setting {
+	s.restartOnly = true
+	return s
+}
+
+// Name returns the name of the setting.
+func (s *Setting) Name() string {
+	return s.name
+}
+
+// Source returns where the current value came from, which is one of the Source* constants.
+func (s *Setting) Source() string {
+	return s.source
+}
+
+// EnvName returns the name of the environment variable the setting is read from.
+func (s *Setting) EnvName() string {
+	return EnvPrefix + strings.ToUpper(splitWords(s.name, "_"))
+}
+
+// FlagName returns the name of the command line flag that sets the setting.
+func (s *Setting) FlagName() string {
+	return strings.ToLower(splitWords(s.name, "-"))
+}
+
+// String returns the current value of the setting in the form it would be written in the environment.
+func (s *Setting) String() string {
+	return s.format(s.value())
+}
+
+// value returns a copy of the current value of the setting.
+func (s *Setting) value() any {
+	switch p := s.ptr.(type) {
+	case *string:
+		return *p
+	case *bool:
+		return *p
+	case *int:
+		return *p
+	case *int64:
+		return *p
+	case *uint32:
+		return *p
+	case *float64:
+		return *p
+	case *time.Duration:
+		return *p
+	case *fs.FileMode:
+		return *p
+	case *[]string:
+		return append([]string(nil), *p...)
+	}
+	return nil
+}
+
+func (s *Setting) format(v any) string {
+	switch n := v.(type) {
+	case string:
+		return n
+	case bool:
+		return strconv.FormatBool(n)
+	case int:
+		return strconv.Itoa(n)
+	case int64:
+		return strconv.FormatInt(n, 10)
+	case uint32:
+		return strconv.FormatUint(uint64(n), 10)
+	case float64:
+		return strconv.FormatFloat(n, 'g', -1, 64)
+	case time.Duration:
+		return n.String()
+	case fs.FileMode:
+		return fmt.Sprintf("%#o", uint32(n))
+	case []string:
+		return strings.Join(n, ",")
+	}
+	return ""
+}
+
+// parse converts a value from a file, environment variable or flag into the type of the setting.
+// Strings are parsed. Numbers, booleans and lists decoded from a file are converted.
+func (s *Setting) parse(v any) (any, error) {
+	if str, ok := v.(string); ok {
+		return s.parseString(str)
+	}
+	switch s.ptr.(type) {
+	case *string:
+		switch v.(type) {
+		case int, int64, float64, bool:
+			return fmt.Sprint(v), nil
+		}
+	case *bool:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case *int, *int64, *uint32, *fs.FileMode:
+		if n, ok := toInt(v); ok {
+			return s.convertInt(n)
+		}
+	case *float64:
+		switch n := v.(type) {
+		case int:
+			return float64(n), nil
+		case int64:
+			return float64(n), nil
+		case float64:
+			return n, nil
+		}
+	case *time.Duration:
+		// A bare number is a number of seconds
+		switch n := v.(type) {
+		case int:
+			return time.Duration(n) * time.Second, nil
+		case int64:
+			return time.Duration(n) * time.Second, nil
+		case float64:
+			return time.Duration(n * float64(time.Second)), nil
+		}
+	case *[]string:
+		if items, ok := v.([]any); ok {
+			l := make([]string, 0, len(items))
+			for _, item := range items {
+				str, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("expected a list of strings, got an item of type %T", item)
+				}
+				l = append(l, str)
+			}
+			return l, nil
+		}
+	}
+	return nil, fmt.Errorf("expected %s, got %T", s.typeName(), v)
+}
+
+func (s *Setting) parseString(str string) (any, error) {
+	switch s.ptr.(type) {
+	case *string:
+		return str, nil
+	case *bool:
+		return strconv.ParseBool(str)
+	case *int, *int64, *uint32:
+		n, err := strconv.ParseInt(strings.TrimSpace(str), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", str)
+		}
+		return s.convertInt(n)
+	case *fs.FileMode:
+		// Modes are written in octal, with or without a leading zero
+		n, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(str), "0o"), 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an octal file mode", str)
+		}
+		return s.convertInt(int64(n))
+	case *float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", str)
+		}
+		return f, nil
+	case *time.Duration:
+		d, err := time.ParseDuration(strings.TrimSpace(str))
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a duration, like 20s or 1m30s", str)
+		}
+		return d, nil
+	case *[]string:
+		if str == "" {
+			return []string(nil), nil
+		}
+		l := strings.Split(str, ",")
+		for i := range l {
+			l[i] = strings.TrimSpace(l[i])
+		}
+		return l, nil
+	}
+	return nil, fmt.Errorf("unsupported type %T", s.ptr)
+}
+
+func (s *Setting) convertInt(n int64) (any, error) {
+	switch s.ptr.(type) {
+	case *int:
+		if int64(int(n)) != n {
+			return nil, fmt.Errorf("%d is too large", n)
+		}
+		return int(n), nil
+	case *int64:
+		return n, nil
+	case *uint32:
+		if n < 0 || n > 1<<32-1 {
+			return nil, fmt.Errorf("%d is out of range for an unsigned 32 bit integer", n)
+		}
+		return uint32(n), nil
+	case *fs.FileMode:
+		if n < 0 || fs.FileMode(n)&^fs.ModePerm != 0 {
+			return nil, fmt.Errorf("%#o is not a valid file permission", n)
+		}
+		return fs.FileMode(n), nil
+	}
+	return nil, fmt.Errorf("unsupported type %T", s.ptr)
+}
+
+// check validates a parsed value against the range and validation function of the setting.
+func (s *Setting) check(v any) error {
+	if s.hasRange {
+		var f float64
+		switch n := v.(type) {
+		case int:
+			f = float64(n)
+		case int64:
+			f = float64(n)
+		case uint32:
+			f = float64(n)
+		case float64:
+			f = n
+		case time.Duration:
+			f = n.Seconds()
+		}
+		if f < s.min || f > s.max {
+			if _, ok := v.(time.Duration); ok {
+				return fmt.Errorf("%v is out of range, it must be between %v and %v",
+					v, time.Duration(s.min*float64(time.Second)), time.Duration(s.max*float64(time.Second)))
+			}
+			return fmt.Errorf("%v is out of range, it must be between %v and %v", v, s.min, s.max)
+		}
+	}
+	if s.validate != nil {
+		return s.validate(v)
+	}
+	return nil
+}
+
+func (s *Setting) assign(v any) {
+	switch p := s.ptr.(type) {
+	case *string:
+		*p = v.(string)
+	case *bool:
+		*p = v.(bool)
+	case *int:
+		*p = v.(int)
+	case *int64:
+		*p = v.(int64)
+	case *uint32:
+		*p = v.(uint32)
+	case *float64:
+		*p = v.(float64)
+	case *time.Duration:
+		*p = v.(time.Duration)
+	case *fs.FileMode:
+		*p = v.(fs.FileMode)
+	case *[]string:
+		*p = v.([]string)
+	}
+}
+
+func (s *Setting) typeName() string {
+	switch s.ptr.(type) {
+	case *string:
+		return "a string"
+	case *bool:
+		return "a boolean"
+	case *int, *int64, *uint32:
+		return "an integer"
+	case *float64:
+		return "a number"
+	case *time.Duration:
+		return "a duration"
+	case *fs.FileMode:
+		return "an octal file mode"
+	case *[]string:
+		return "a list of strings"
+	}
+	return fmt.Sprintf("%T", s.ptr)
+}
+
+// flagValue lets a Setting be used as a flag.Value. The value is only recorded when the flag is parsed,
+// and is assigned by Load so that the order of precedence is kept.
+type flagValue struct {
+	s *Setting
+}
+
+func (f flagValue) String() string {
+	if f.s == nil {
+		return ""
+	}
+	return f.s.String()
+}
+
+func (f flagValue) Set(str string) error {
+	if _, err := f.s.parseString(str); err != nil {
+		return err
+	}
+	f.s.flagVal = &str
+	return nil
+}
+
+func (f flagValue) IsBoolFlag() bool {
+	_, ok := f.s.ptr.(*bool)
+	return ok
+}
+
+// RegisterFlags defines a command line flag on flags for each registered setting.
+// The flag name is the setting name in lower case words separated by dashes, as in -read-timeout.
+//
+// Call it before flags.Parse, and call Load after.
+func RegisterFlags(flags *flag.FlagSet) {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	captureDefaults()
+	for _, s := range settings {
+		flags.Var(flagValue{s}, s.FlagName(), s.usage)
+	}
+}
+
+// Load sets the registered configuration variables from the given file, the environment and the command line
+// flags registered with RegisterFlags, in that order, so that a flag overrides an environment variable, which
+// overrides the file, which overrides the default set in the code.
+//
+// The file may be YAML or JSON, depending on its extension, and holds a map of setting names to values.
+// Names are matched without regard to case. Durations may be written like "20s", or as a number of seconds.
+// If file is blank, no file is read.
+//
+// Every value is checked before any is assigned, and if any value is invalid, or the file names an unknown
+// setting, an error describing all of the problems is returned and none of the variables are changed.
+// The functions registered with OnChange are told about the values that changed.
+// Call Load from main() before starting the server. Typical use is:
+//
+//	func main() {
+//		configFile := flag.String("config", "", "configuration file")
+//		config.RegisterFlags(flag.CommandLine)
+//		flag.Parse()
+//		if err := config.Load(*configFile); err != nil {
+//			log.Fatal(err)
+//		}
+//		...
+func Load(file string) error {
+	_, err := load(file, false)
+	return err
+}
+
+// Reload reads the file given to Load and the environment again, and changes the settings whose values are different.
+// The flags keep the values they were given when the application started.
+// A setting that is no longer given a value in the file or the environment goes back to its default.
+//
+// As with Load, either all the settings are changed or none are. If a setting marked with RestartOnly would change,
+// an error wrapping ErrRestartRequired is returned. The changes are returned and passed to the functions registered
+// with OnChange. Reload is safe to call from any goroutine, like an admin request handler.
+// See also serve.ReloadOnSignal.
+func Reload() ([]Change, error) {
+	settingsMu.Lock()
+	file := loadedFile
+	settingsMu.Unlock()
+	return load(file, true)
+}
+
+func load(file string, reload bool) ([]Change, error) {
+	var fileValues map[string]any
+	if file != "" {
+		var err error
+		if fileValues, err = readFile(file); err != nil {
+			return nil, err
+		}
+	}
+
+	settingsMu.Lock()
+	values, errs := resolve(file, fileValues)
+	if reload {
+		for _, v := range values {
+			if v.s.restartOnly && v.s.format(v.v) != v.s.String() {
+				errs = append(errs, fmt.Errorf("%s: %w", v.s.name, ErrRestartRequired))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		settingsMu.Unlock()
+		return nil, fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	loadedFile = file
+	var changes []Change
+	valuesMu.Lock()
+	for _, v := range values {
+		if v.s.format(v.v) != v.s.String() {
+			changes = append(changes, Change{Name: v.s.name, Old: v.s.value(), New: v.v})
+			v.s.assign(v.v)
+		}
+		v.s.source = v.source
+		v.s.origin = v.origin
+	}
+	valuesMu.Unlock()
+	subs := slices.Clone(subscribers)
+	settingsMu.Unlock()
+
+	if len(changes) > 0 {
+		for _, f := range subs {
+			f(changes)
+		}
+	}
+	return changes, nil
+}
+
+type resolved struct {
+	s      *Setting
+	v      any
+	source string
+	origin string
+}
+
+// resolve works out the value of every setting from its default, the file values, the environment and the flags.
+// The settings lock must be held.
+func resolve(file string, fileValues map[string]any) (values []resolved, errs []error) {
+	captureDefaults()
+	for key := range fileValues {
+		if findSettingFold(key) == nil {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", file, key))
+		}
+	}
+
+	for _, s := range settings {
+		r := resolved{s, s.defVal, SourceDefault, ""}
+		for key, raw := range fileValues {
+			if strings.EqualFold(key, s.name) {
+				v, err := s.parse(raw)
+				if err == nil {
+					err = s.check(v)
+				}
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: %s: %w", file, s.name, err))
+				} else {
+					r = resolved{s, v, SourceFile, file}
+				}
+				break
+			}
+		}
+		if str, ok := os.LookupEnv(s.EnvName()); ok {
+			v, err := s.parseString(str)
+			if err == nil {
+				err = s.check(v)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("environment variable %s: %w", s.EnvName(), err))
+			} else {
+				r = resolved{s, v, SourceEnv, s.EnvName()}
+			}
+		}
+		if s.flagVal != nil {
+			v, err := s.parseString(*s.flagVal)
+			if err == nil {
+				err = s.check(v)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("flag -%s: %w", s.FlagName(), err))
+			} else {
+				r = resolved{s, v, SourceFlag, "-" + s.FlagName()}
+			}
+		}
+		values = append(values, r)
+	}
+	return
+}
+
+// captureDefaults records the current values of the settings as their defaults, the first time it is called
+// for each setting. That happens after all the init() functions have run, so changes made to the variables
+// in code are kept. The settings lock must be held.
+func captureDefaults() {
+	for _, s := range settings {
+		if !s.hasDef {
+			s.defVal = s.value()
+			s.hasDef = true
+		}
+	}
+}
+
+// Value returns the value of the configuration variable that p points to. Use it to read a setting that
+// Reload can change, from any goroutine other than the one that called Reload. Reading the variable
+// directly while Reload assigns it would be a data race.
+//
+//	timeout := config.Value(&config.ShutdownTimeout)
+func Value[T any](p *T) T {
+	valuesMu.RLock()
+	defer valuesMu.RUnlock()
+	return *p
+}
+
+// OnChange registers a function that is called with the list of settings that changed each time Load or Reload
+// changes the value of one or more settings. The function is called after all the new values have been assigned.
+//
+// Use it to pass new values on to the parts of the application that cannot read the configuration
+// variables directly while requests are being served. You may call this from an init() function.
+func OnChange(f func(changes []Change)) {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	subscribers = append(subscribers, f)
+}
+
+// WriteSettings writes the current value of each registered setting to w, along with where the value came from.
+// Use it to check the configuration an application is actually running with.
+func WriteSettings(w io.Writer) error {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, s := range settings {
+		source := s.source
+		if s.origin != "" {
+			source += " " + s.origin
+		}
+		if _, err := fmt.Fprintf(tw, "%s\t%q\t%s\n", s.name, s.String(), source); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// Settings returns the registered settings in the order they were registered.
+func Settings() []*Setting {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	return append([]*Setting(nil), settings...)
+}
+
+func readFile(file string) (map[string]any, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		if err = d.Decode(&values); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		for k, v := range values {
+			values[k] = fromJSON(v)
+		}
+	case ".yaml", ".yml":
+		if err = yaml.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	default:
+		return nil, fmt.Errorf("%s: the configuration file must end in .yaml, .yml or .json", file)
+	}
+	return values, nil
+}
+
+// fromJSON converts json.Number values to the int and float64 values that the yaml decoder produces.
+func fromJSON(v any) any {
+	switch n := v.(type) {
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return i
+		}
+		f, _ := n.Float64()
+		return f
+	case []any:
+		for i := range n {
+			n[i] = fromJSON(n[i])
+		}
+	}
+	return v
+}
+
+func toInt(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint64:
+		if n <= 1<<63-1 {
+			return int64(n), true
+		}
+	case float64:
+		if n == float64(int64(n)) {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
+
+func findSetting(name string) *Setting {
+	for _, s := range settings {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func findSettingFold(name string) *Setting {
+	for _, s := range settings {
+		if strings.EqualFold(s.name, name) {
+			return s
+		}
+	}
+	return nil
+}
+
+// splitWords splits a CamelCase name into words joined by sep. A run of capitals is treated as one word,
+// so "HTTP2MaxReadFrameSize" becomes "HTTP2-Max-Read-Frame-Size".
+func splitWords(name string, sep string) string {
+	var b strings.Builder
+	r := []rune(name)
+	for i, c := range r {
+		if i > 0 && unicode.IsUpper(c) {
+			prev := r[i-1]
+			nextLower := i+1 < len(r) && unicode.IsLower(r[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteString(sep)
+			}
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
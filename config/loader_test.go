@@ -0,0 +1,202 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testTimeout = 5 * time.Second
+var testName = "default"
+var testHosts []string
+var testCount = 3
+var testPort = 80
+
+func init() {
+	RegisterSetting("TestPort", "test", &testPort).RestartOnly()
+	RegisterSetting("TestTimeout", "test", &testTimeout).Range(1, 60)
+	RegisterSetting("TestName", "test", &testName)
+	RegisterSetting("TestHosts", "test", &testHosts)
+	RegisterSetting("TestCount", "test", &testCount).Range(0, 10)
+}
+
+func resetTestSettings() {
+	testTimeout = 5 * time.Second
+	testName = "default"
+	testHosts = nil
+	testCount = 3
+	testPort = 80
+	loadedFile = ""
+	for _, s := range Settings() {
+		s.source = SourceDefault
+		s.origin = ""
+		s.flagVal = nil
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	f := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(f, []byte(content), 0600))
+	return f
+}
+
+func TestNames(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string
+		flag string
+	}{
+		{"ReadTimeout", "GORADD_READ_TIMEOUT", "read-timeout"},
+		{"MaxConnectionsPerIP", "GORADD_MAX_CONNECTIONS_PER_IP", "max-connections-per-ip"},
+		{"HTTP2MaxReadFrameSize", "GORADD_HTTP2_MAX_READ_FRAME_SIZE", "http2-max-read-frame-size"},
+		{"DefaultFormFieldWrapperIdSuffix", "GORADD_DEFAULT_FORM_FIELD_WRAPPER_ID_SUFFIX", "default-form-field-wrapper-id-suffix"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := findSetting(tt.name)
+			require.NotNil(t, s)
+			assert.Equal(t, tt.env, s.EnvName())
+			assert.Equal(t, tt.flag, s.FlagName())
+		})
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	defer resetTestSettings()
+	f := writeFile(t, "app.yaml", "TestTimeout: 10s\ntestName: fromFile\nTestHosts: [a.com, b.com]\nTestCount: 4\n")
+	t.Setenv("GORADD_TEST_NAME", "fromEnv")
+	t.Setenv("GORADD_TEST_COUNT", "5")
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	RegisterFlags(flags)
+	require.NoError(t, flags.Parse([]string{"-test-count", "6"}))
+
+	require.NoError(t, Load(f))
+	assert.Equal(t, 10*time.Second, testTimeout)
+	assert.Equal(t, "fromEnv", testName)
+	assert.Equal(t, []string{"a.com", "b.com"}, testHosts)
+	assert.Equal(t, 6, testCount)
+
+	assert.Equal(t, SourceFile, findSetting("TestTimeout").Source())
+	assert.Equal(t, SourceEnv, findSetting("TestName").Source())
+	assert.Equal(t, SourceFlag, findSetting("TestCount").Source())
+	assert.Equal(t, SourceDefault, findSetting("ReadTimeout").Source())
+
+	var b strings.Builder
+	require.NoError(t, WriteSettings(&b))
+	assert.Regexp(t, `TestName +"fromEnv" +env GORADD_TEST_NAME`, b.String())
+	assert.Regexp(t, `TestCount +"6" +flag -test-count`, b.String())
+}
+
+func TestLoadJSON(t *testing.T) {
+	defer resetTestSettings()
+	f := writeFile(t, "app.json", `{"TestTimeout": 1.5, "TestHosts": ["x"]}`)
+	require.NoError(t, Load(f))
+	assert.Equal(t, 1500*time.Millisecond, testTimeout)
+	assert.Equal(t, []string{"x"}, testHosts)
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		wantErr string
+	}{
+		{"unknown", "TestTimout: 10s", `unknown setting "TestTimout"`},
+		{"range", "TestTimeout: 2m", "out of range"},
+		{"type", "TestCount: many", "is not an integer"},
+		{"list", "TestHosts: [1, 2]", "expected a list of strings"},
+		{"path", "AssetPath: assets", "must be blank or start with a /"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer resetTestSettings()
+			f := writeFile(t, "app.yml", tt.file+"\nTestName: changed\n")
+			err := Load(f)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+			assert.Equal(t, "default", testName, "nothing is assigned if there is an error")
+		})
+	}
+}
+
+func TestLoadBadFlag(t *testing.T) {
+	defer resetTestSettings()
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(new(strings.Builder))
+	RegisterFlags(flags)
+	assert.Error(t, flags.Parse([]string{"-read-timeout", "soon"}))
+}
+
+func TestReload(t *testing.T) {
+	defer resetTestSettings()
+	var got []Change
+	OnChange(func(changes []Change) {
+		got = changes
+	})
+
+	f := writeFile(t, "app.yaml", "TestTimeout: 10s\nTestName: first\n")
+	require.NoError(t, Load(f))
+	assert.Len(t, got, 2)
+
+	// Removing TestName from the file puts it back to its default
+	require.NoError(t, os.WriteFile(f, []byte("TestTimeout: 10s\nTestCount: 7\n"), 0600))
+	changes, err := Reload()
+	require.NoError(t, err)
+	assert.Equal(t, []Change{
+		{Name: "TestName", Old: "first", New: "default"},
+		{Name: "TestCount", Old: 3, New: 7},
+	}, changes)
+	assert.Equal(t, changes, got)
+	assert.Equal(t, "default", testName)
+	assert.Equal(t, SourceDefault, findSetting("TestName").Source())
+	assert.Equal(t, 7, testCount)
+
+	// Nothing changed
+	got = nil
+	changes, err = Reload()
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+	assert.Nil(t, got, "subscribers are not called if nothing changed")
+
+	// A restart-only setting stops the whole reload
+	require.NoError(t, os.WriteFile(f, []byte("TestTimeout: 20s\nTestPort: 8080\n"), 0600))
+	_, err = Reload()
+	assert.True(t, errors.Is(err, ErrRestartRequired))
+	assert.Contains(t, err.Error(), "TestPort")
+	assert.Equal(t, 10*time.Second, testTimeout)
+	assert.Equal(t, 80, testPort)
+}
+
+func TestLoadRestartOnly(t *testing.T) {
+	defer resetTestSettings()
+	f := writeFile(t, "app.yaml", "TestPort: 8080\n")
+	require.NoError(t, Load(f), "restart-only settings may be set by Load")
+	assert.Equal(t, 8080, testPort)
+}
+
+func TestValue(t *testing.T) {
+	defer resetTestSettings()
+	f := writeFile(t, "app.yaml", "TestTimeout: 10s\n")
+	require.NoError(t, Load(f))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = Value(&testTimeout)
+		}
+	}()
+	require.NoError(t, os.WriteFile(f, []byte("TestTimeout: 20s\n"), 0600))
+	_, err := Reload()
+	require.NoError(t, err)
+	<-done
+	assert.Equal(t, 20*time.Second, Value(&testTimeout))
+}
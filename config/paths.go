@@ -13,6 +13,19 @@ var AssetPath = "/assets/"
 // or set to blank to turn off handling of websockets.
 var WebsocketMessengerPath = "/ws/"
 
+// HealthPath is the url path of the liveness endpoint, which reports whether the application is working.
+// Set to blank to turn off the endpoint. See the health package.
+var HealthPath = "/healthz"
+
+// ReadyPath is the url path of the readiness endpoint, which reports whether the application is ready
+// to accept requests. Set to blank to turn off the endpoint. See the health package.
+var ReadyPath = "/readyz"
+
+// MetricsPath is the url path of the endpoint that serves the metrics of the server in the Prometheus text format,
+// like "/metrics". It is blank by default, which turns off the endpoint, since the metrics reveal the routes of
+// the application. See the metrics package.
+var MetricsPath string
+
 // ProxyPath is the url path to the application. By default, this is the root, but you can set it
 // to any path. This is particularly useful to making the application appear as if it is running in a subdirectory
 // of the root path. This is great for putting behind an Apache server, and using ProxyPass and ProxyPassReverse to direct
@@ -32,4 +45,8 @@ var WebsocketMessengerPath = "/ws/"
 // because the standard Go MuxServer needs to know the full path in order to do its redirects.
 // This also means you should NEVER use http.StripPath in front of a MUX handler.
 // ProxyPath should start with a / character
+//
+// If the proxy does strip the path, and sends it in an X-Forwarded-Prefix header instead, leave ProxyPath blank,
+// add the proxy to TrustedProxies, and make paths with http.MakeRequestLocalPath. Redirect and the other functions
+// that add ProxyPath for you do not know the prefix.
 var ProxyPath string
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strings"
+)
+
+// The settings in this package that can be loaded with Load.
+//
+// The settings that are copied into the servers, used to register handlers, or read directly by goradd
+// are marked RestartOnly. The others are read with Value, or passed on by the functions registered with OnChange.
+func init() {
+	RegisterSetting("ReadTimeout", "time a client has to send its request", &ReadTimeout).Range(0, 3600).RestartOnly()
+	RegisterSetting("ReadHeaderTimeout", "time a client has to send its headers, or 0 to use ReadTimeout", &ReadHeaderTimeout).Range(0, 3600).RestartOnly()
+	RegisterSetting("WriteTimeout", "time the server has to write its response", &WriteTimeout).Range(0, 3600).RestartOnly()
+	RegisterSetting("IdleTimeout", "time a keep-alive connection may wait for the next request", &IdleTimeout).Range(0, 86400).RestartOnly()
+	RegisterSetting("ShutdownTimeout", "time the server has to finish requests when shutting down", &ShutdownTimeout).Range(0, 3600)
+	RegisterSetting("ShutdownDrainDelay", "time the server keeps accepting requests after a shutdown begins", &ShutdownDrainDelay).Range(0, 600)
+	RegisterSetting("UpgradeReadyTimeout", "time a new process started by an upgrade has to start serving", &UpgradeReadyTimeout).Range(1, 3600).RestartOnly()
+	RegisterSetting("MaxConnectionsPerIP", "maximum open connections from one IP address, or 0 for no limit", &MaxConnectionsPerIP).Range(0, 1e6).RestartOnly()
+	RegisterSetting("TrustedProxies", "addresses or CIDR ranges of the proxies whose forwarding headers are trusted, or unix for unix socket peers", &TrustedProxies).Validate(addressList).RestartOnly()
+	RegisterSetting("MaxRequestsInFlight", "maximum requests served at a time, or 0 for no limit", &MaxRequestsInFlight).Range(0, 1e6).RestartOnly()
+	RegisterSetting("MaxRequestQueue", "maximum requests waiting to be served", &MaxRequestQueue).Range(0, 1e6).RestartOnly()
+	RegisterSetting("RequestQueueTimeout", "time a request may wait to be served, or 0 for no limit", &RequestQueueTimeout).Range(0, 600).RestartOnly()
+	RegisterSetting("MaxHeaderCount", "maximum header lines in a request, or 0 for no limit", &MaxHeaderCount).Range(0, 1e6).RestartOnly()
+	RegisterSetting("MaxHeaderSize", "maximum total bytes of the headers of a request, or 0 for no limit", &MaxHeaderSize).Range(0, 1<<30).RestartOnly()
+	RegisterSetting("MaxURLLength", "maximum bytes in the url of a request, or 0 for no limit", &MaxURLLength).Range(0, 1<<30).RestartOnly()
+	RegisterSetting("AllowedMethods", "http methods the server answers, or empty for any", &AllowedMethods).RestartOnly()
+	RegisterSetting("AllowedHosts", "host names the server answers to, or empty for any", &AllowedHosts).RestartOnly()
+	RegisterSetting("FirewallMonitorOnly", "log the requests the request validator would reject without rejecting them", &FirewallMonitorOnly).RestartOnly()
+	RegisterSetting("AccessLogFormat", "format of the access log: common, combined or json, or blank for none", &AccessLogFormat).Validate(accessLogFormat).RestartOnly()
+	RegisterSetting("AccessLogExclude", "url path prefixes left out of the access log", &AccessLogExclude).RestartOnly()
+	RegisterSetting("TraceExporter", "where trace spans are sent: stdout or otlp, or blank for none", &TraceExporter).Validate(traceExporter).RestartOnly()
+	RegisterSetting("TraceEndpoint", "url of the traces endpoint of the OpenTelemetry collector", &TraceEndpoint).RestartOnly()
+	RegisterSetting("TraceServiceName", "name of the application in traces", &TraceServiceName).RestartOnly()
+	RegisterSetting("TraceSampleRatio", "fraction of new traces that are recorded", &TraceSampleRatio).Range(0, 1).RestartOnly()
+	RegisterSetting("LogLevel", "lowest level of the messages sent to the logger", &LogLevel).Validate(logLevel)
+	RegisterSetting("Maintenance", "answer requests for dynamic pages with 503 Service Unavailable", &Maintenance)
+	RegisterSetting("HstsMaxAge", "HSTS timeout in seconds, or -1 to turn off HSTS", &HstsMaxAge).Range(-1, 1<<31)
+	RegisterSetting("HstsIncludeSubdomains", "apply the HSTS policy to subdomains", &HstsIncludeSubdomains)
+	RegisterSetting("HstsPreload", "ask for the domain to be put in the browsers' HSTS preload lists", &HstsPreload)
+	RegisterSetting("SecurityHeaders", "send security headers like Content-Security-Policy", &SecurityHeaders).RestartOnly()
+	RegisterSetting("ContentSecurityPolicy", "Content-Security-Policy sent in place of the default", &ContentSecurityPolicy)
+	RegisterSetting("CSPReportOnly", "report violations of the Content-Security-Policy without blocking them", &CSPReportOnly)
+	RegisterSetting("CSPNonce", "add a nonce to the Content-Security-Policy and the inline scripts and styles of each page", &CSPNonce).RestartOnly()
+	RegisterSetting("CSPNonceTags", "add the Content-Security-Policy nonce to the inline scripts and styles of each page", &CSPNonceTags).RestartOnly()
+	RegisterSetting("CSRF", "reject form posts and ajax calls that do not have a CSRF token", &CSRF).RestartOnly()
+	RegisterSetting("AjaxTimeout", "milliseconds the browser waits for an ajax response", &AjaxTimeout).Range(0, 3.6e6).RestartOnly()
+
+	RegisterSetting("CacheBusterPrefix", "fragment included in cache busted paths", &CacheBusterPrefix).RestartOnly()
+	RegisterSetting("DefaultPage", "page served for a path ending in /", &DefaultPage).Validate(noSlash).RestartOnly()
+	RegisterSetting("AssetPath", "url path prefix of the assets", &AssetPath).Validate(urlPath).RestartOnly()
+	RegisterSetting("WebsocketMessengerPath", "url path prefix of the websocket messenger", &WebsocketMessengerPath).Validate(urlPath).RestartOnly()
+	RegisterSetting("HealthPath", "url path of the liveness endpoint", &HealthPath).Validate(urlPath).RestartOnly()
+	RegisterSetting("ReadyPath", "url path of the readiness endpoint", &ReadyPath).Validate(urlPath).RestartOnly()
+	RegisterSetting("MetricsPath", "url path of the metrics endpoint", &MetricsPath).Validate(urlPath).RestartOnly()
+	RegisterSetting("ProxyPath", "url path the application is served from behind a proxy", &ProxyPath).Validate(urlPath).RestartOnly()
+
+	RegisterSetting("DefaultDateFormat", "format used to display dates", &DefaultDateFormat).RestartOnly()
+	RegisterSetting("DefaultTimeFormat", "format used to display times", &DefaultTimeFormat).RestartOnly()
+	RegisterSetting("DefaultDateTimeFormat", "format used to display dates with times", &DefaultDateTimeFormat).RestartOnly()
+	RegisterSetting("DefaultDateEntryFormat", "format used to enter dates", &DefaultDateEntryFormat).RestartOnly()
+	RegisterSetting("DefaultTimeEntryFormat", "format used to enter times", &DefaultTimeEntryFormat).RestartOnly()
+	RegisterSetting("DefaultDateTimeEntryFormat", "format used to enter dates with times", &DefaultDateTimeEntryFormat).RestartOnly()
+	RegisterSetting("SelectOneString", "item shown in a selection list when a selection is required", &SelectOneString).RestartOnly()
+	RegisterSetting("NoSelectionString", "item shown in a selection list for no selection", &NoSelectionString).RestartOnly()
+	RegisterSetting("DefaultFormFieldWrapperIdSuffix", "suffix added to form field wrapper ids", &DefaultFormFieldWrapperIdSuffix).RestartOnly()
+
+	RegisterSetting("DevCertificateDir", "directory of the development certificates", &DevCertificateDir).RestartOnly()
+	RegisterSetting("DevCertificateHosts", "extra hosts the development certificate is valid for", &DevCertificateHosts).RestartOnly()
+	RegisterSetting("UnixSocketMode", "file mode of a unix domain socket", &UnixSocketMode).RestartOnly()
+	RegisterSetting("UnixSocketUser", "user that owns a unix domain socket", &UnixSocketUser).RestartOnly()
+	RegisterSetting("UnixSocketGroup", "group that owns a unix domain socket", &UnixSocketGroup).RestartOnly()
+	RegisterSetting("HTTP2MaxConcurrentStreams", "streams per h2c connection, or 0 for the default", &HTTP2MaxConcurrentStreams).RestartOnly()
+	RegisterSetting("HTTP2MaxReadFrameSize", "largest h2c frame read, or 0 for the default", &HTTP2MaxReadFrameSize).Validate(frameSize).RestartOnly()
+	RegisterSetting("HTTP2MaxUpgradeBodySize", "largest request body on an h2c upgrade", &HTTP2MaxUpgradeBodySize).Range(0, 1<<30).RestartOnly()
+}
+
+// urlPath checks that a path setting is blank or starts with a slash.
+func urlPath(v any) error {
+	if p := v.(string); p != "" && !strings.HasPrefix(p, "/") {
+		return errors.New("the path must be blank or start with a /")
+	}
+	return nil
+}
+
+func addressList(v any) error {
+	for _, a := range v.([]string) {
+		if a == "unix" {
+			continue
+		}
+		if _, err := netip.ParsePrefix(a); err == nil {
+			continue
+		}
+		if _, err := netip.ParseAddr(a); err != nil {
+			return fmt.Errorf("%q is not an IP address or CIDR range", a)
+		}
+	}
+	return nil
+}
+
+func noSlash(v any) error {
+	if strings.Contains(v.(string), "/") {
+		return errors.New("the page may not contain a /")
+	}
+	return nil
+}
+
+func frameSize(v any) error {
+	if n := v.(uint32); n != 0 && (n < 1<<14 || n > 1<<24-1) {
+		return errors.New("the frame size must be 0, or between 16384 and 16777215")
+	}
+	return nil
+}
+
+func logLevel(v any) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(v.(string))); err != nil {
+		return errors.New("the level must be debug, info, warn or error")
+	}
+	return nil
+}
+
+func accessLogFormat(v any) error {
+	switch strings.ToLower(v.(string)) {
+	case "", "common", "combined", "json":
+		return nil
+	}
+	return errors.New("the format must be common, combined or json")
+}
+
+func traceExporter(v any) error {
+	switch v.(string) {
+	case "", "stdout", "otlp":
+		return nil
+	}
+	return errors.New("the exporter must be stdout or otlp")
+}
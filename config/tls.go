@@ -0,0 +1,13 @@
+package config
+
+// DevCertificateDir is the directory where the development certificate authority and the
+// certificates it signs are cached. If blank, a "goradd/devcert" directory inside of the
+// user's cache directory is used.
+//
+// The development certificates are only available when Release is false.
+var DevCertificateDir string
+
+// DevCertificateHosts are host names and IP addresses, in addition to localhost, that the
+// development certificate will be valid for. Add the names you use to reach your
+// development machine from other devices, like a phone on the local network.
+var DevCertificateHosts []string
@@ -0,0 +1,18 @@
+package config
+
+import "io/fs"
+
+// UnixSocketMode is the file mode given to a unix domain socket created by the server when
+// it is listening on an address like "unix:/run/app/app.sock".
+//
+// The web server in front of the application needs read and write permission on the socket.
+var UnixSocketMode fs.FileMode = 0660
+
+// UnixSocketUser is the name or numeric id of the user that will own a unix domain socket created by the server.
+// If blank, the socket is owned by the user running the application.
+var UnixSocketUser string
+
+// UnixSocketGroup is the name or numeric id of the group that will own a unix domain socket created by the server.
+// Set this to the group of the web server in front of the application, like "www-data".
+// If blank, the socket is owned by the primary group of the user running the application.
+var UnixSocketGroup string
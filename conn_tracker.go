@@ -0,0 +1,170 @@
+package serve
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/goradd/serve/config"
+	"github.com/goradd/serve/log"
+)
+
+const logModule = "serve"
+
+// ConnCounts is a snapshot of the connections seen by a ConnTracker.
+type ConnCounts struct {
+	// New is the number of connections that have been accepted but have not yet sent a request.
+	New int
+	// Active is the number of connections that are currently reading a request or writing a response.
+	Active int
+	// Idle is the number of keep-alive connections that are waiting for a new request.
+	Idle int
+	// Hijacked is the total number of connections that have been taken over by a handler, like the websocket server.
+	// These are no longer tracked once they are hijacked.
+	Hijacked int
+	// Closed is the total number of connections that have been closed.
+	Closed int
+	// Rejected is the total number of connections that were closed because the remote address
+	// had too many open connections.
+	Rejected int
+}
+
+// Open returns the number of connections that are currently open and not hijacked.
+func (c ConnCounts) Open() int {
+	return c.New + c.Active + c.Idle
+}
+
+// LogValue lets the counts be sent to the structured logger.
+func (c ConnCounts) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int("new", c.New),
+		slog.Int("active", c.Active),
+		slog.Int("idle", c.Idle),
+		slog.Int("hijacked", c.Hijacked),
+		slog.Int("closed", c.Closed),
+		slog.Int("rejected", c.Rejected),
+	)
+}
+
+// ConnTracker keeps track of the state of the connections to a group of servers through the
+// http.Server ConnState hook.
+//
+// Watching the counts can help you debug problems like a slowloris attack, where a client opens
+// many connections and then sends its requests very slowly. Such connections show up as New or Active
+// connections that do not go away until the config.ReadTimeout expires.
+//
+// ConnTracker can also limit the number of connections open from a single remote address.
+type ConnTracker struct {
+	// MaxPerIP is the maximum number of connections that may be open from one remote IP address at a time.
+	// Connections beyond that are closed immediately. Zero means config.MaxConnectionsPerIP is used, which is
+	// read as each connection is opened, so that it can be loaded after the tracker is made. Set it to -1
+	// for no limit.
+	MaxPerIP int
+
+	mu     sync.Mutex
+	states map[net.Conn]http.ConnState
+	perIP  map[string]int
+	counts ConnCounts
+}
+
+// NewConnTracker returns a new ConnTracker that limits connections by config.MaxConnectionsPerIP.
+func NewConnTracker() *ConnTracker {
+	return &ConnTracker{
+		states: make(map[net.Conn]http.ConnState),
+		perIP:  make(map[string]int),
+	}
+}
+
+// maxPerIP returns the limit on the connections from one IP address, or zero for no limit.
+func (t *ConnTracker) maxPerIP() int {
+	if t.MaxPerIP != 0 {
+		return max(t.MaxPerIP, 0)
+	}
+	return config.MaxConnectionsPerIP
+}
+
+// ConnState records a change in the state of a connection. Assign it to the ConnState field of an http.Server.
+func (t *ConnTracker) ConnState(c net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ip := remoteIP(c)
+	if prev, ok := t.states[c]; ok {
+		t.adjust(prev, -1)
+	}
+
+	switch state {
+	case http.StateNew:
+		t.states[c] = state
+		t.adjust(state, 1)
+		t.perIP[ip]++
+		if limit := t.maxPerIP(); limit > 0 && t.perIP[ip] > limit {
+			t.counts.Rejected++
+			log.Info(nil, logModule, "Too many connections from remote address",
+				slog.String("ip", ip),
+				slog.Int("count", t.perIP[ip]))
+			// Closing the connection here causes the server to report it as closed, which cleans up the counts.
+			_ = c.Close()
+		}
+	case http.StateActive, http.StateIdle:
+		t.states[c] = state
+		t.adjust(state, 1)
+	case http.StateHijacked, http.StateClosed:
+		if _, ok := t.states[c]; ok {
+			delete(t.states, c)
+			if t.perIP[ip]--; t.perIP[ip] <= 0 {
+				delete(t.perIP, ip)
+			}
+		}
+		t.adjust(state, 1)
+	}
+}
+
+func (t *ConnTracker) adjust(state http.ConnState, n int) {
+	switch state {
+	case http.StateNew:
+		t.counts.New += n
+	case http.StateActive:
+		t.counts.Active += n
+	case http.StateIdle:
+		t.counts.Idle += n
+	case http.StateHijacked:
+		t.counts.Hijacked += n
+	case http.StateClosed:
+		t.counts.Closed += n
+	}
+}
+
+// Counts returns the current connection counts.
+func (t *ConnTracker) Counts() ConnCounts {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.counts
+}
+
+// IPCount returns the number of open connections from the given remote IP address.
+func (t *ConnTracker) IPCount(ip string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.perIP[ip]
+}
+
+// Log sends the current connection counts to the info logger with the given message.
+func (t *ConnTracker) Log(ctx context.Context, msg string) {
+	log.Info(ctx, logModule, msg, slog.Any("connections", t.Counts()))
+}
+
+// remoteIP returns the IP address portion of the remote address of c.
+func remoteIP(c net.Conn) string {
+	a := c.RemoteAddr()
+	if a == nil {
+		return ""
+	}
+	s := a.String()
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		return host
+	}
+	return s
+}
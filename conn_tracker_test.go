@@ -0,0 +1,90 @@
+package serve
+
+import (
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/goradd/serve/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type testConn struct {
+	net.Conn
+	addr   string
+	closed bool
+}
+
+func (c *testConn) RemoteAddr() net.Addr {
+	a, _ := net.ResolveTCPAddr("tcp", c.addr)
+	return a
+}
+
+func (c *testConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestConnTracker(t *testing.T) {
+	tr := NewConnTracker()
+	c1 := &testConn{addr: "10.0.0.1:1000"}
+	c2 := &testConn{addr: "10.0.0.1:1001"}
+	c3 := &testConn{addr: "10.0.0.2:1000"}
+
+	tr.ConnState(c1, http.StateNew)
+	tr.ConnState(c2, http.StateNew)
+	tr.ConnState(c3, http.StateNew)
+	assert.Equal(t, ConnCounts{New: 3}, tr.Counts())
+	assert.Equal(t, 2, tr.IPCount("10.0.0.1"))
+
+	tr.ConnState(c1, http.StateActive)
+	tr.ConnState(c2, http.StateActive)
+	tr.ConnState(c2, http.StateIdle)
+	assert.Equal(t, ConnCounts{New: 1, Active: 1, Idle: 1}, tr.Counts())
+	assert.Equal(t, 3, tr.Counts().Open())
+
+	tr.ConnState(c1, http.StateHijacked)
+	tr.ConnState(c2, http.StateClosed)
+	tr.ConnState(c3, http.StateClosed)
+	assert.Equal(t, ConnCounts{Hijacked: 1, Closed: 2}, tr.Counts())
+	assert.Equal(t, 0, tr.IPCount("10.0.0.1"))
+}
+
+func TestConnTracker_MaxPerIP(t *testing.T) {
+	tr := NewConnTracker()
+	tr.MaxPerIP = 1
+	c1 := &testConn{addr: "10.0.0.1:1000"}
+	c2 := &testConn{addr: "10.0.0.1:1001"}
+	c3 := &testConn{addr: "10.0.0.2:1000"}
+
+	tr.ConnState(c1, http.StateNew)
+	tr.ConnState(c2, http.StateNew)
+	tr.ConnState(c3, http.StateNew)
+	assert.False(t, c1.closed)
+	assert.True(t, c2.closed)
+	assert.False(t, c3.closed)
+	assert.Equal(t, 1, tr.Counts().Rejected)
+
+	// the server reports the close of the rejected connection
+	tr.ConnState(c2, http.StateClosed)
+	assert.Equal(t, 1, tr.IPCount("10.0.0.1"))
+	assert.Equal(t, 2, tr.Counts().Open())
+}
+
+func TestConnTracker_MaxPerIPFromConfig(t *testing.T) {
+	tr := NewConnTracker()
+	// The setting is loaded after the tracker is made, as happens with the default Runner
+	config.MaxConnectionsPerIP = 1
+	defer func() { config.MaxConnectionsPerIP = 0 }()
+
+	c1 := &testConn{addr: "10.0.0.1:1000"}
+	c2 := &testConn{addr: "10.0.0.1:1001"}
+	tr.ConnState(c1, http.StateNew)
+	tr.ConnState(c2, http.StateNew)
+	assert.True(t, c2.closed)
+
+	tr.MaxPerIP = -1
+	c3 := &testConn{addr: "10.0.0.1:1002"}
+	tr.ConnState(c3, http.StateNew)
+	assert.False(t, c3.closed, "-1 turns off the limit")
+}
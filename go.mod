@@ -4,20 +4,23 @@ go 1.25.4
 
 require (
 	github.com/alexedwards/scs/v2 v2.9.0
+	github.com/andybalholm/brotli v1.0.6
 	github.com/goradd/goradd v0.31.10
+	github.com/goradd/html5tag v1.0.3
 	github.com/goradd/maps v1.2.0
+	github.com/gorilla/websocket v1.5.0
+	github.com/microcosm-cc/bluemonday v1.0.26
 	github.com/stretchr/testify v1.11.0
+	github.com/yuin/goldmark v1.7.13
+	golang.org/x/net v0.19.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	github.com/andybalholm/brotli v1.0.6 // indirect
 	github.com/aymerick/douceur v0.2.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/goradd/gofile v1.1.1 // indirect
 	github.com/gorilla/css v1.0.0 // indirect
-	github.com/microcosm-cc/bluemonday v1.0.26 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/yuin/goldmark v1.7.13 // indirect
-	golang.org/x/net v0.19.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	golang.org/x/text v0.14.0 // indirect
 )
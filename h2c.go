@@ -0,0 +1,51 @@
+package serve
+
+import (
+	"net/http"
+
+	"github.com/goradd/serve/config"
+	"golang.org/x/net/http/httpguts"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// AddH2CListener adds a listener at addr that accepts cleartext HTTP/2 (h2c) along with HTTP/1.1, and sends
+// requests to handler.
+//
+// Use this when the application is behind a proxy that talks HTTP/2 to its backends over a private network.
+// Clients may start HTTP/2 either with prior knowledge, or by asking to upgrade an HTTP/1.1 connection.
+// Websocket requests continue to use HTTP/1.1, so the websocket route on the PatternMuxer works as before.
+// Stream and frame limits come from config.HTTP2MaxConcurrentStreams and config.HTTP2MaxReadFrameSize.
+//
+// Never use h2c on a connection open to the public internet. Use AddTLSListener instead, which serves HTTP/2 over TLS.
+func (r *Runner) AddH2CListener(addr string, handler http.Handler) (*http.Server, error) {
+	l := &listener{server: newServer(addr, handler)}
+	if err := l.configureH2C(); err != nil {
+		return nil, err
+	}
+	return r.add(l).server, nil
+}
+
+// configureH2C wraps the handler of the listener's server with an h2c handler.
+func (l *listener) configureH2C() error {
+	s := l.server
+	h2s := &http2.Server{
+		MaxConcurrentStreams: config.HTTP2MaxConcurrentStreams,
+		MaxReadFrameSize:     config.HTTP2MaxReadFrameSize,
+		IdleTimeout:          config.IdleTimeout,
+	}
+	// This lets Shutdown gracefully close the HTTP/2 connections, which are hijacked from the http.Server.
+	if err := http2.ConfigureServer(s, h2s); err != nil {
+		return err
+	}
+	h := h2c.NewHandler(s.Handler, h2s)
+	limit := config.HTTP2MaxUpgradeBodySize
+	s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only the upgrade request is read into memory, so other requests keep the limits of the application.
+		if httpguts.HeaderValuesContainsToken(r.Header["Upgrade"], "h2c") {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		h.ServeHTTP(w, r)
+	})
+	return nil
+}
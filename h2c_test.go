@@ -0,0 +1,128 @@
+package serve
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goradd/serve/config"
+	grhttp "github.com/goradd/serve/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+func TestRunner_AddH2CListener(t *testing.T) {
+	var sawTLS atomic.Bool
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			sawTLS.Store(true)
+		}
+		_, _ = io.WriteString(w, r.Proto)
+	}
+	r := NewRunner()
+	_, err := r.AddH2CListener("127.0.0.1:0", grhttp.WithBufferedOutput(http.HandlerFunc(fn)))
+	require.NoError(t, err)
+	assert.False(t, r.listeners[0].isTLS())
+
+	done := make(chan error)
+	go func() {
+		done <- r.ListenAndServe()
+	}()
+	addr := listenerAddr(t, r, 0)
+
+	// prior knowledge
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	resp, err := client.Get("http://" + addr + "/")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "HTTP/2.0", string(body))
+
+	// plain HTTP/1.1 still works
+	resp, err = http.Get("http://" + addr + "/")
+	require.NoError(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "HTTP/1.1", string(body))
+
+	// the listener is cleartext only
+	assert.False(t, sawTLS.Load())
+	_, err = tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	assert.Error(t, err, "the listener does not negotiate TLS")
+
+	assert.NoError(t, r.Shutdown(context.Background()))
+	assert.ErrorIs(t, <-done, http.ErrServerClosed)
+}
+
+func TestRunner_AddH2CListener_Upgrade(t *testing.T) {
+	defer func(l int64) { config.HTTP2MaxUpgradeBodySize = l }(config.HTTP2MaxUpgradeBodySize)
+	config.HTTP2MaxUpgradeBodySize = 100
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		_, _ = fmt.Fprintf(w, "%s %d", r.Proto, len(b))
+	}
+	r := NewRunner()
+	_, err := r.AddH2CListener("127.0.0.1:0", http.HandlerFunc(fn))
+	require.NoError(t, err)
+	done := make(chan error)
+	go func() {
+		done <- r.ListenAndServe()
+	}()
+	addr := listenerAddr(t, r, 0)
+
+	upgrade := func(body string) string {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = fmt.Fprintf(conn, "POST / HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade, HTTP2-Settings\r\n"+
+			"Upgrade: h2c\r\nHTTP2-Settings: AAMAAABkAAQAAP__\r\nContent-Length: %d\r\n\r\n%s", addr, len(body), body)
+		require.NoError(t, err)
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		require.NoError(t, err)
+		return strings.TrimSpace(line)
+	}
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols", upgrade("small"))
+	assert.Equal(t, "HTTP/1.1 500 Internal Server Error", upgrade(strings.Repeat("x", 200)),
+		"the body of the upgrade request is limited")
+
+	// The limit does not apply to requests that do not upgrade
+	resp, err := http.Post("http://"+addr+"/", "text/plain", strings.NewReader(strings.Repeat("x", 200)))
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "HTTP/1.1 200", string(body))
+
+	assert.NoError(t, r.Shutdown(context.Background()))
+	assert.ErrorIs(t, <-done, http.ErrServerClosed)
+}
+
+// listenerAddr waits for the i-th listener of r to start, and returns its address.
+func listenerAddr(t *testing.T, r *Runner, i int) string {
+	for range 100 {
+		r.mu.Lock()
+		ln := r.listeners[i].ln
+		r.mu.Unlock()
+		if ln != nil {
+			return ln.Addr().String()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the listener did not start")
+	return ""
+}
@@ -0,0 +1,220 @@
+// Package health serves liveness and readiness endpoints that an orchestrator or load balancer can poll.
+//
+// Subsystems register named checks. The liveness endpoint reports whether the process is working at all,
+// and should only fail when restarting the process would fix the problem. The readiness endpoint reports
+// whether the application can serve requests right now. It also fails as soon as the server starts to
+// shut down, so that load balancers stop sending new requests while the requests in progress finish.
+//
+// Both endpoints respond with a JSON description of the results of each check, and a status code of
+// 200 if all checks passed, or 503 if any of them failed.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goradd/serve/log"
+)
+
+const logModule = "health"
+
+// DefaultTimeout is the amount of time a check may take when it is registered with a timeout of zero.
+var DefaultTimeout = 2 * time.Second
+
+// CheckFunc checks one part of the application. It should return an error describing the problem if
+// that part is not working. The context is cancelled when the check's timeout expires.
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name    string
+	timeout time.Duration
+	f       CheckFunc
+}
+
+var mu sync.Mutex
+var livenessChecks []check
+var readinessChecks []check
+var shuttingDown atomic.Bool
+
+// ErrShuttingDown is the readiness error reported after SetShuttingDown is called.
+var ErrShuttingDown = errors.New("the server is shutting down")
+
+// RegisterLivenessCheck registers a check that is run by the liveness endpoint.
+// Registering a check with the same name as an existing one replaces it.
+// You may call this from an init() function.
+func RegisterLivenessCheck(name string, timeout time.Duration, f CheckFunc) {
+	register(&livenessChecks, check{name, timeout, f})
+}
+
+// RegisterReadinessCheck registers a check that is run by the readiness endpoint.
+// Registering a check with the same name as an existing one replaces it.
+// You may call this from an init() function.
+func RegisterReadinessCheck(name string, timeout time.Duration, f CheckFunc) {
+	register(&readinessChecks, check{name, timeout, f})
+}
+
+func register(checks *[]check, c check) {
+	mu.Lock()
+	defer mu.Unlock()
+	for i := range *checks {
+		if (*checks)[i].name == c.name {
+			(*checks)[i] = c
+			return
+		}
+	}
+	*checks = append(*checks, c)
+}
+
+// SetShuttingDown causes the readiness endpoint to fail from now on.
+// The server calls this at the start of a shutdown.
+func SetShuttingDown() {
+	shuttingDown.Store(true)
+}
+
+// IsShuttingDown returns true if SetShuttingDown has been called.
+func IsShuttingDown() bool {
+	return shuttingDown.Load()
+}
+
+// CheckResult is the result of one check.
+type CheckResult struct {
+	Status   string  `json:"status"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"durationMs"`
+}
+
+// Report is the response of a health endpoint.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// OK returns true if all the checks passed.
+func (r Report) OK() bool {
+	return r.Status == StatusOK
+}
+
+// Values of the Status fields of a Report and a CheckResult.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Liveness runs the liveness checks and returns the results.
+func Liveness(ctx context.Context) Report {
+	return run(ctx, &livenessChecks, false)
+}
+
+// Readiness runs the readiness checks and returns the results.
+func Readiness(ctx context.Context) Report {
+	return run(ctx, &readinessChecks, true)
+}
+
+// run runs the checks concurrently.
+func run(ctx context.Context, checks *[]check, isReadiness bool) Report {
+	mu.Lock()
+	list := append([]check(nil), *checks...)
+	mu.Unlock()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(list))}
+	results := make([]CheckResult, len(list))
+	var wg sync.WaitGroup
+	for i, c := range list {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = runCheck(ctx, c)
+		}()
+	}
+	wg.Wait()
+
+	for i, c := range list {
+		report.Checks[c.name] = results[i]
+		if results[i].Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	if isReadiness && IsShuttingDown() {
+		report.Status = StatusFail
+		report.Checks["shutdown"] = CheckResult{Status: StatusFail, Error: ErrShuttingDown.Error()}
+	}
+	return report
+}
+
+// runCheck runs one check, converting a panic or an expired timeout into a failure.
+func runCheck(ctx context.Context, c check) CheckResult {
+	timeout := c.timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- c.f(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := CheckResult{
+		Status:   StatusOK,
+		Duration: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+		log.Warn(ctx, logModule, "Health check failed",
+			slog.String("check", c.name),
+			slog.Any("error", err))
+	}
+	return result
+}
+
+// LivenessHandler returns a handler that serves the liveness report.
+func LivenessHandler() http.Handler {
+	return reportHandler(Liveness)
+}
+
+// ReadinessHandler returns a handler that serves the readiness report.
+func ReadinessHandler() http.Handler {
+	return reportHandler(Readiness)
+}
+
+func reportHandler(f func(ctx context.Context) Report) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		report := f(r.Context())
+		b, err := json.Marshal(report)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if report.OK() {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_, _ = w.Write(b)
+	}
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,85 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goradd/serve/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func reset() {
+	livenessChecks = nil
+	readinessChecks = nil
+	shuttingDown.Store(false)
+}
+
+func serve(t *testing.T, h http.Handler) (int, Report) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var report Report
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	return w.Code, report
+}
+
+func TestReadiness(t *testing.T) {
+	log.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)), "")
+	reset()
+	defer reset()
+
+	code, report := serve(t, ReadinessHandler())
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusOK, report.Status)
+
+	var dbErr error
+	RegisterReadinessCheck("db", 0, func(ctx context.Context) error { return dbErr })
+	RegisterReadinessCheck("cache", 0, func(ctx context.Context) error { return nil })
+	code, report = serve(t, ReadinessHandler())
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, report.Checks, 2)
+
+	dbErr = errors.New("no connection")
+	code, report = serve(t, ReadinessHandler())
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Equal(t, "no connection", report.Checks["db"].Error)
+	assert.Equal(t, StatusOK, report.Checks["cache"].Status)
+
+	// replace the check
+	RegisterReadinessCheck("db", 0, func(ctx context.Context) error { return nil })
+	code, _ = serve(t, ReadinessHandler())
+	assert.Equal(t, http.StatusOK, code)
+
+	// liveness is not affected by shutting down
+	SetShuttingDown()
+	code, report = serve(t, ReadinessHandler())
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusFail, report.Checks["shutdown"].Status)
+	code, _ = serve(t, LivenessHandler())
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestCheckFailures(t *testing.T) {
+	log.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)), "")
+	reset()
+	defer reset()
+
+	RegisterLivenessCheck("slow", 10*time.Millisecond, func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	RegisterLivenessCheck("panic", 0, func(ctx context.Context) error { panic("oops") })
+	report := Liveness(context.Background())
+	assert.False(t, report.OK())
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks["slow"].Error)
+	assert.Equal(t, "panic: oops", report.Checks["panic"].Error)
+}
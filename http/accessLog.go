@@ -0,0 +1,260 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goradd/serve/log"
+)
+
+// AccessLogFormat is the format of the lines written by an AccessLogger.
+type AccessLogFormat int
+
+const (
+	// AccessLogCommon is the Apache Common Log Format.
+	AccessLogCommon AccessLogFormat = iota
+	// AccessLogCombined is the Apache Combined Log Format, which adds the referer and user agent to the Common format.
+	AccessLogCombined
+	// AccessLogJSON writes each request as a JSON object on its own line.
+	AccessLogJSON
+)
+
+// ParseAccessLogFormat returns the format with the given name, which is "common", "combined" or "json".
+func ParseAccessLogFormat(name string) (AccessLogFormat, error) {
+	switch strings.ToLower(name) {
+	case "common":
+		return AccessLogCommon, nil
+	case "combined":
+		return AccessLogCombined, nil
+	case "json":
+		return AccessLogJSON, nil
+	}
+	return 0, fmt.Errorf("unknown access log format %q", name)
+}
+
+// AccessLogEntry describes a request that has been served.
+type AccessLogEntry struct {
+	Time       time.Time     `json:"time"`
+	RemoteAddr string        `json:"remote_addr"`
+	User       string        `json:"user,omitempty"`
+	Method     string        `json:"method"`
+	URI        string        `json:"uri"`
+	Proto      string        `json:"proto"`
+	Status     int           `json:"status"`
+	Bytes      int64         `json:"bytes"`
+	Duration   time.Duration `json:"duration_ns"`
+	Referer    string        `json:"referer,omitempty"`
+	UserAgent  string        `json:"user_agent,omitempty"`
+	// SessionID identifies the session without revealing the session token. See session.LogID.
+	SessionID string `json:"session_id,omitempty"`
+	// RequestID is the ID given to the request by WithRequestID.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type accessLogContext struct{}
+
+// AccessLogEntryFromContext returns the entry that the access log will write for the current request,
+// or nil if the request is not being logged. Handlers deeper in the stack can use it to fill in
+// details that the access logger cannot see, like the SessionID.
+func AccessLogEntryFromContext(ctx context.Context) *AccessLogEntry {
+	e, _ := ctx.Value(accessLogContext{}).(*AccessLogEntry)
+	return e
+}
+
+// AccessLogger is middleware that records each request that is served.
+type AccessLogger struct {
+	// Format is the format of each log line.
+	Format AccessLogFormat
+	// Writer receives each log line. If nil, the entries are sent to the log package at the Info level,
+	// with the JSON format sending the fields as structured attributes.
+	Writer io.Writer
+	// ExcludePrefixes are the url paths that are not logged, like the health endpoints and the asset path.
+	// A path is excluded if it starts with one of the prefixes.
+	ExcludePrefixes []string
+
+	mu sync.Mutex
+}
+
+// NewAccessLogger returns a new AccessLogger that writes in the given format to w.
+func NewAccessLogger(format AccessLogFormat, w io.Writer) *AccessLogger {
+	return &AccessLogger{Format: format, Writer: w}
+}
+
+// Use is the middleware function that logs the requests served by next.
+//
+// It should be the first stage of the handler stack, so that the time it records includes the time spent
+// in all the other stages. The number of bytes is what was written to the connection, which for buffered output
+// is the length of the output buffer when it was flushed.
+func (l *AccessLogger) Use(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if l.excluded(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		e := &AccessLogEntry{
+			Time:       time.Now(),
+			RemoteAddr: r.RemoteAddr,
+			Method:     r.Method,
+			URI:        r.RequestURI,
+			Proto:      r.Proto,
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+			RequestID:  log.RequestID(r.Context()),
+		}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			e.RemoteAddr = host
+		}
+		if u, _, ok := r.BasicAuth(); ok {
+			e.User = u
+		}
+		sw := &statusWriter{ResponseWriter: w}
+		r = r.WithContext(context.WithValue(r.Context(), accessLogContext{}, e))
+		defer func() {
+			// Log requests that panic too. The error handler will normally catch them before they get here.
+			e.Duration = time.Since(e.Time)
+			e.Status = sw.status
+			if e.Status == 0 {
+				e.Status = http.StatusOK
+			}
+			e.Bytes = sw.bytes
+			l.write(r.Context(), e)
+		}()
+		next.ServeHTTP(sw, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+func (l *AccessLogger) excluded(p string) bool {
+	for _, prefix := range l.ExcludePrefixes {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *AccessLogger) write(ctx context.Context, e *AccessLogEntry) {
+	if l.Writer == nil {
+		if l.Format == AccessLogJSON {
+			log.Info(ctx, "access", "Request", e.attrs()...)
+		} else {
+			log.Info(ctx, "access", l.format(e))
+		}
+		return
+	}
+	var line []byte
+	if l.Format == AccessLogJSON {
+		line, _ = json.Marshal(e)
+	} else {
+		line = []byte(l.format(e))
+	}
+	line = append(line, '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.Writer.Write(line)
+}
+
+// format returns the entry in the Common or Combined Log Format.
+func (l *AccessLogger) format(e *AccessLogEntry) string {
+	var b strings.Builder
+	b.WriteString(dash(e.RemoteAddr))
+	b.WriteString(" - ")
+	b.WriteString(dash(e.User))
+	b.WriteString(e.Time.Format(" [02/Jan/2006:15:04:05 -0700] "))
+	b.WriteString(strconv.Quote(e.Method + " " + e.URI + " " + e.Proto))
+	b.WriteString(" ")
+	b.WriteString(strconv.Itoa(e.Status))
+	b.WriteString(" ")
+	if e.Bytes == 0 {
+		b.WriteString("-")
+	} else {
+		b.WriteString(strconv.FormatInt(e.Bytes, 10))
+	}
+	if l.Format == AccessLogCombined {
+		b.WriteString(" ")
+		b.WriteString(strconv.Quote(e.Referer))
+		b.WriteString(" ")
+		b.WriteString(strconv.Quote(e.UserAgent))
+	}
+	return b.String()
+}
+
+func (e *AccessLogEntry) attrs() []any {
+	return []any{
+		slog.String("remote_addr", e.RemoteAddr),
+		slog.String("user", e.User),
+		slog.String("method", e.Method),
+		slog.String("uri", e.URI),
+		slog.String("proto", e.Proto),
+		slog.Int("status", e.Status),
+		slog.Int64("bytes", e.Bytes),
+		slog.Duration("duration", e.Duration),
+		slog.String("referer", e.Referer),
+		slog.String("user_agent", e.UserAgent),
+		slog.String("session_id", e.SessionID),
+	}
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// statusWriter records the status code and the number of bytes written to a ResponseWriter.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets handlers that stream their output flush it through the access logger.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the websocket server take over the connection through the access logger.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("the ResponseWriter does not support Hijack")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the writer underneath.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
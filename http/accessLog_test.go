@@ -0,0 +1,73 @@
+package http
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLogger(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if e := AccessLogEntryFromContext(r.Context()); e != nil {
+			e.SessionID = "abc123"
+		}
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = io.WriteString(w, "hello")
+	})
+
+	tests := []struct {
+		name    string
+		format  AccessLogFormat
+		path    string
+		pattern string
+	}{
+		{"common", AccessLogCommon, "/page?x=1",
+			`^192\.0\.2\.1 - bob \[\d\d/\w\w\w/\d{4}:\d\d:\d\d:\d\d [+-]\d{4}\] "GET /page\?x=1 HTTP/1\.1" 200 5\n$`},
+		{"combined", AccessLogCombined, "/missing",
+			`^192\.0\.2\.1 - bob \[.+\] "GET /missing HTTP/1\.1" 404 19 "http://example\.com/" "test-agent"\n$`},
+		{"excluded", AccessLogCommon, "/healthz", `^$`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			l := NewAccessLogger(tt.format, &b)
+			l.ExcludePrefixes = []string{"/healthz", "/assets/"}
+			r := httptest.NewRequest("GET", tt.path, nil)
+			r.SetBasicAuth("bob", "secret")
+			r.Header.Set("Referer", "http://example.com/")
+			r.Header.Set("User-Agent", "test-agent")
+			l.Use(handler).ServeHTTP(httptest.NewRecorder(), r)
+			assert.Regexp(t, tt.pattern, b.String())
+		})
+	}
+}
+
+func TestAccessLogger_JSON(t *testing.T) {
+	var b strings.Builder
+	l := NewAccessLogger(AccessLogJSON, &b)
+	h := WithBufferedOutput(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		AccessLogEntryFromContext(r.Context()).SessionID = "abc123"
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, `{"id":1}`)
+	}))
+	l.Use(h).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api", nil))
+
+	var e AccessLogEntry
+	require.NoError(t, json.Unmarshal([]byte(b.String()), &e))
+	assert.Equal(t, "POST", e.Method)
+	assert.Equal(t, "/api", e.URI)
+	assert.Equal(t, http.StatusCreated, e.Status)
+	assert.Equal(t, int64(8), e.Bytes)
+	assert.Equal(t, "abc123", e.SessionID)
+	assert.Equal(t, "192.0.2.1", e.RemoteAddr)
+	assert.Positive(t, e.Duration)
+}
@@ -0,0 +1,254 @@
+package http
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goradd/serve/log"
+)
+
+// CORSPolicy lets pages served from other origins, like a single page application on its own domain, call the
+// routes it is registered for. See RegisterCORSPolicy.
+type CORSPolicy struct {
+	// AllowedOrigins are the origins allowed to call the routes, like "https://app.example.com".
+	// An origin like "https://*.example.com" allows every subdomain of example.com, and "*" allows any origin.
+	AllowedOrigins []string
+	// AllowedMethods are the methods that may be used. It defaults to GET, HEAD and POST.
+	AllowedMethods []string
+	// AllowedHeaders are the request headers that may be sent, other than the ones browsers always allow.
+	// "*" allows any header.
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers, other than the ones browsers always allow, that the calling page may read.
+	ExposedHeaders []string
+	// AllowCredentials lets the calling page send cookies and read the response.
+	// It may not be used with the "*" origin.
+	AllowCredentials bool
+	// MaxAge is how long the browser may cache the answer to a preflight request.
+	// If it is zero, the browser decides, which is usually a few seconds.
+	MaxAge time.Duration
+}
+
+type prefixCORSPolicy struct {
+	pattern string
+	policy  CORSPolicy
+}
+
+var corsMu sync.RWMutex
+var corsPolicies []prefixCORSPolicy // longest pattern first
+
+// RegisterCORSPolicy sets the CORS policy of the requests whose path matches pattern. A pattern that ends
+// in a slash matches every path that starts with it, and other patterns match only that path.
+// If more than one pattern matches, the longest one wins.
+//
+//	http.RegisterCORSPolicy("/api/", http.CORSPolicy{
+//		AllowedOrigins:   []string{"https://app.example.com"},
+//		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"},
+//		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+//		AllowCredentials: true,
+//		MaxAge:           time.Hour,
+//	})
+//
+// You may call this from an init() function.
+func RegisterCORSPolicy(pattern string, p CORSPolicy) {
+	if len(p.AllowedOrigins) == 0 {
+		panic("a CORS policy needs at least one allowed origin")
+	}
+	p.AllowedOrigins = slices.Clone(p.AllowedOrigins)
+	for i, o := range p.AllowedOrigins {
+		if o == "*" && p.AllowCredentials {
+			panic("a CORS policy that allows credentials cannot allow any origin")
+		}
+		p.AllowedOrigins[i] = strings.ToLower(o)
+	}
+	if len(p.AllowedMethods) == 0 {
+		p.AllowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+	pattern = joinProxyPath(pattern)
+	corsMu.Lock()
+	defer corsMu.Unlock()
+	for i := range corsPolicies {
+		if corsPolicies[i].pattern == pattern {
+			corsPolicies[i].policy = p
+			return
+		}
+	}
+	corsPolicies = append(corsPolicies, prefixCORSPolicy{pattern, p})
+	sort.SliceStable(corsPolicies, func(i, j int) bool {
+		return len(corsPolicies[i].pattern) > len(corsPolicies[j].pattern)
+	})
+}
+
+// CORSPolicyFor returns the CORS policy registered for path, and false if there is none.
+func CORSPolicyFor(path string) (CORSPolicy, bool) {
+	corsMu.RLock()
+	defer corsMu.RUnlock()
+	for _, p := range corsPolicies {
+		if p.pattern == path || p.pattern[len(p.pattern)-1] == '/' && strings.HasPrefix(path, p.pattern) {
+			return p.policy, true
+		}
+	}
+	return CORSPolicy{}, false
+}
+
+// WithCORS is middleware that applies the CORS policies registered with RegisterCORSPolicy.
+//
+// Preflight requests are answered here, so the handlers never see them. Other requests from allowed
+// origins get the Access-Control-Allow-Origin header and the other headers of the policy, and requests
+// from other origins are passed on without them, so that the browser keeps the calling page from reading the response.
+//
+// It must come before the muxers, so that it can answer preflight requests for routes of either one.
+func WithCORS(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		p, ok := CORSPolicyFor(r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		anyOrigin := !p.AllowCredentials && p.allowsAnyOrigin()
+		if !anyOrigin {
+			// The answer depends on the origin, so shared caches must keep one copy per origin
+			h.Add("Vary", "Origin")
+		}
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			p.preflight(w, r, origin, anyOrigin)
+			return
+		}
+
+		if p.allowsOrigin(origin) {
+			p.setOrigin(h, origin, anyOrigin)
+			if len(p.ExposedHeaders) > 0 {
+				h.Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+			}
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// preflight answers a preflight request. If the request is not allowed, the answer has no CORS headers,
+// and the browser will not make the actual request.
+func (p *CORSPolicy) preflight(w http.ResponseWriter, r *http.Request, origin string, anyOrigin bool) {
+	method := r.Header.Get("Access-Control-Request-Method")
+	headers := splitList(r.Header.Values("Access-Control-Request-Headers"))
+	var reason string
+	switch {
+	case !p.allowsOrigin(origin):
+		reason = "origin not allowed"
+	case !p.allowsMethod(method):
+		reason = "method not allowed"
+	case !p.allowsHeaders(headers):
+		reason = "header not allowed"
+	}
+	if reason != "" {
+		log.Debug(r.Context(), logModule, "CORS preflight refused",
+			slog.String("reason", reason),
+			slog.String("origin", origin),
+			slog.String("method", method),
+			slog.String("path", r.URL.Path))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	h := w.Header()
+	p.setOrigin(h, origin, anyOrigin)
+	h.Set("Access-Control-Allow-Methods", strings.Join(p.AllowedMethods, ", "))
+	if len(headers) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if p.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *CORSPolicy) setOrigin(h http.Header, origin string, anyOrigin bool) {
+	if anyOrigin {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	if p.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (p *CORSPolicy) allowsAnyOrigin() bool {
+	for _, o := range p.AllowedOrigins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *CORSPolicy) allowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, o := range p.AllowedOrigins {
+		if o == "*" || o == origin {
+			return true
+		}
+		// A pattern like https://*.example.com
+		if before, after, ok := strings.Cut(o, "*"); ok &&
+			len(origin) > len(before)+len(after) &&
+			strings.HasPrefix(origin, before) &&
+			strings.HasSuffix(origin, after) &&
+			validSubdomain(origin[len(before):len(origin)-len(after)]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *CORSPolicy) allowsMethod(method string) bool {
+	for _, m := range p.AllowedMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *CORSPolicy) allowsHeaders(headers []string) bool {
+	for _, h := range headers {
+		found := false
+		for _, a := range p.AllowedHeaders {
+			if a == "*" || strings.EqualFold(a, h) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// validSubdomain returns true if s is made of lower case DNS labels, like "a.b".
+func validSubdomain(s string) bool {
+	if s[0] == '.' || s[len(s)-1] == '.' || strings.Contains(s, "..") {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORSPolicy_allowsOrigin(t *testing.T) {
+	p := CORSPolicy{AllowedOrigins: []string{"https://app.example.com", "https://*.example.org"}}
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://app.example.com", true},
+		{"https://APP.example.com", true},
+		{"http://app.example.com", false},
+		{"https://a.b.example.org", true},
+		{"https://.example.org", false},
+		{"https://example.org", false},
+		{"https://evil.com/.example.org", false},
+		{"https://evil.com:1.example.org", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, p.allowsOrigin(tt.origin), tt.origin)
+	}
+}
+
+func TestWithCORS(t *testing.T) {
+	clearGlobals()
+	defer func() { corsPolicies = nil }()
+
+	RegisterCORSPolicy("/api/", CORSPolicy{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedMethods:   []string{"GET", "PUT"},
+		AllowedHeaders:   []string{"Content-Type"},
+		ExposedHeaders:   []string{"X-Total"},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	})
+	RegisterCORSPolicy("/public", CORSPolicy{AllowedOrigins: []string{"*"}})
+	assert.Panics(t, func() {
+		RegisterCORSPolicy("/bad", CORSPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+	})
+
+	var called bool
+	h := WithCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+	serve := func(method, path string, headers map[string]string) *httptest.ResponseRecorder {
+		called = false
+		r := httptest.NewRequest(method, path, nil)
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("OPTIONS", "/api/items", map[string]string{
+		"Origin":                         "https://app.example.com",
+		"Access-Control-Request-Method":  "PUT",
+		"Access-Control-Request-Headers": "content-type",
+	})
+	assert.False(t, called, "preflight is answered by the middleware")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "GET, PUT", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "content-type", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "3600", w.Header().Get("Access-Control-Max-Age"))
+	assert.Equal(t, []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}, w.Header().Values("Vary"))
+
+	w = serve("OPTIONS", "/api/items", map[string]string{
+		"Origin":                        "https://app.example.com",
+		"Access-Control-Request-Method": "DELETE",
+	})
+	assert.False(t, called)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"), "method not allowed")
+
+	w = serve("GET", "/api/items", map[string]string{"Origin": "https://app.example.com"})
+	assert.True(t, called)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Total", w.Header().Get("Access-Control-Expose-Headers"))
+	assert.Equal(t, "Origin", w.Header().Get("Vary"))
+
+	w = serve("GET", "/api/items", map[string]string{"Origin": "https://evil.com"})
+	assert.True(t, called)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Origin", w.Header().Get("Vary"), "caches must not reuse the answer for another origin")
+
+	w = serve("GET", "/public", map[string]string{"Origin": "https://evil.com"})
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("Vary"), "the answer is the same for every origin")
+
+	w = serve("OPTIONS", "/public/x", map[string]string{"Origin": "https://a.com", "Access-Control-Request-Method": "GET"})
+	assert.True(t, called, "/public only matches itself")
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
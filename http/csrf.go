@@ -0,0 +1,164 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"html"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/goradd/serve/config"
+	"github.com/goradd/serve/log"
+	"github.com/goradd/serve/session"
+)
+
+// CSRFFieldName is the name of the form field that carries the CSRF token in a form post.
+const CSRFFieldName = "Goradd__Csrf"
+
+// CSRFHeader is the request header that carries the CSRF token in ajax calls.
+const CSRFHeader = "X-CSRF-Token"
+
+// csrfSessionKey is the session key of the secret that the CSRF tokens of a session are made from.
+const csrfSessionKey = "goradd.csrf"
+
+const csrfSecretLength = 32
+
+var csrfExemptMu sync.RWMutex
+var csrfExempt = make(map[string]bool)
+
+// RegisterCSRFExemption turns off CSRF protection for the route registered with pattern, like a webhook that is
+// called by another server rather than a browser, and that authenticates the caller some other way.
+// pattern must be the same as the pattern given to RegisterAppHandler or RegisterDrawFunc.
+//
+// You may call this from an init() function.
+func RegisterCSRFExemption(pattern string) {
+	csrfExemptMu.Lock()
+	defer csrfExemptMu.Unlock()
+	csrfExempt[joinProxyPath(pattern)] = true
+}
+
+func isCSRFExempt(r *http.Request) bool {
+	csrfExemptMu.RLock()
+	defer csrfExemptMu.RUnlock()
+	if len(csrfExempt) == 0 {
+		return false
+	}
+	_, pattern := AppMuxer.Handler(r)
+	return csrfExempt[pattern]
+}
+
+// WithCSRF is middleware that protects the application from cross-site request forgery when config.CSRF is on.
+//
+// Requests that use a method other than GET, HEAD, OPTIONS or TRACE must have a token made by CSRFToken
+// in the X-CSRF-Token header, or in the Goradd__Csrf form field. Requests without a good token are
+// answered with 403 Forbidden. The tokens are made from a secret kept in the session,
+// so it must come after the session handler.
+//
+// Put CSRFField in the forms of a page, and CSRFMeta in its head so that goradd.js adds the token to its ajax calls.
+// Use RegisterCSRFExemption for routes that are not called by browsers.
+func WithCSRF(next http.Handler) http.Handler {
+	if !config.CSRF {
+		return next
+	}
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if isCSRFExempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		token := r.Header.Get(CSRFHeader)
+		if token == "" {
+			token = r.PostFormValue(CSRFFieldName)
+		}
+		if reason := checkCSRFToken(ctx, token); reason != "" {
+			log.Warn(ctx, logModule, "CSRF check failed",
+				slog.String("reason", reason),
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path))
+			e := Error{ErrCode: http.StatusForbidden, Message: "The request could not be verified. Reload the page and try again."}
+			e.Send()
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// checkCSRFToken returns the reason the token is not good for the session in ctx, or an empty string if it is.
+func checkCSRFToken(ctx context.Context, token string) string {
+	if !session.HasSession(ctx) {
+		return "no session"
+	}
+	if token == "" {
+		return "missing token"
+	}
+	secret := csrfSecret(ctx, false)
+	if secret == nil {
+		return "no secret in session"
+	}
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) != 2*csrfSecretLength {
+		return "malformed token"
+	}
+	pad, masked := b[:csrfSecretLength], b[csrfSecretLength:]
+	for i := range masked {
+		masked[i] ^= pad[i]
+	}
+	if subtle.ConstantTimeCompare(masked, secret) != 1 {
+		return "wrong token"
+	}
+	return ""
+}
+
+// csrfSecret returns the CSRF secret of the session in ctx. If the session has none, a new one is made if
+// create is true, and otherwise nil is returned.
+func csrfSecret(ctx context.Context, create bool) []byte {
+	if s := session.GetString(ctx, csrfSessionKey); s != "" {
+		if b, err := base64.RawURLEncoding.DecodeString(s); err == nil && len(b) == csrfSecretLength {
+			return b
+		}
+	}
+	if !create {
+		return nil
+	}
+	b := make([]byte, csrfSecretLength)
+	_, _ = rand.Read(b)
+	session.SetString(ctx, csrfSessionKey, base64.RawURLEncoding.EncodeToString(b))
+	return b
+}
+
+// CSRFToken returns a token that WithCSRF accepts for the session of the request, or an empty string if the
+// request has no session.
+//
+// Each call returns a different token, since the secret of the session is masked with random bytes, which keeps
+// the secret from being worked out from compressed responses. All of them stay good until the session is reset.
+func CSRFToken(ctx context.Context) string {
+	if !session.HasSession(ctx) {
+		return ""
+	}
+	secret := csrfSecret(ctx, true)
+	b := make([]byte, 2*csrfSecretLength)
+	_, _ = rand.Read(b[:csrfSecretLength])
+	for i := range secret {
+		b[csrfSecretLength+i] = secret[i] ^ b[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// CSRFField returns a hidden form field that holds a CSRF token, to put in the forms of a template.
+func CSRFField(ctx context.Context) string {
+	return `<input type="hidden" name="` + CSRFFieldName + `" value="` + html.EscapeString(CSRFToken(ctx)) + `">`
+}
+
+// CSRFMeta returns a meta tag that holds a CSRF token, to put in the head of a page.
+// goradd.js sends the token with its ajax calls and form posts.
+func CSRFMeta(ctx context.Context) string {
+	return `<meta name="csrf-token" content="` + html.EscapeString(CSRFToken(ctx)) + `">`
+}
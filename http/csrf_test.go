@@ -0,0 +1,75 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/goradd/serve/config"
+	"github.com/goradd/serve/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithCSRF(t *testing.T) {
+	clearGlobals()
+	AppMuxer = http.NewServeMux()
+	config.CSRF = true
+	defer func() {
+		config.CSRF = false
+		csrfExempt = make(map[string]bool)
+	}()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	RegisterAppHandler("/form", ok)
+	RegisterAppHandler("/hook", ok)
+	RegisterCSRFExemption("/hook")
+
+	ctx := session.NewMock().With(context.Background())
+	token := CSRFToken(ctx)
+	assert.NotEqual(t, token, CSRFToken(ctx), "tokens are masked differently each time")
+	other := CSRFToken(session.NewMock().With(context.Background()))
+
+	h := WithErrorHandler(WithCSRF(WithAppMuxer(http.NotFoundHandler())))
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header string
+		field  string
+		want   int
+	}{
+		{"get", "GET", "/form", "", "", http.StatusOK},
+		{"no token", "POST", "/form", "", "", http.StatusForbidden},
+		{"header", "POST", "/form", token, "", http.StatusOK},
+		{"field", "POST", "/form", "", token, http.StatusOK},
+		{"other session", "POST", "/form", other, "", http.StatusForbidden},
+		{"garbage", "DELETE", "/form", "abc", "", http.StatusForbidden},
+		{"exempt", "POST", "/hook", "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			if tt.field != "" {
+				form.Set(CSRFFieldName, tt.field)
+			}
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(form.Encode())).WithContext(ctx)
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.header != "" {
+				r.Header.Set(CSRFHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
+
+func TestCSRFField(t *testing.T) {
+	assert.Empty(t, CSRFToken(context.Background()), "no session, no token")
+	ctx := session.NewMock().With(context.Background())
+	assert.Regexp(t, `^<input type="hidden" name="Goradd__Csrf" value="[\w-]{86}">$`, CSRFField(ctx))
+	assert.Regexp(t, `^<meta name="csrf-token" content="[\w-]{86}">$`, CSRFMeta(ctx))
+}
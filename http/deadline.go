@@ -0,0 +1,205 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/goradd/serve/config"
+	"github.com/goradd/serve/log"
+)
+
+// RouteDeadline is the amount of time the handler of a route has to finish a request.
+type RouteDeadline struct {
+	// Timeout is the amount of time the handler has. When it runs out, the context of the request is cancelled.
+	Timeout time.Duration
+	// StatusCode is the error sent to the browser when the deadline passes. It defaults to
+	// http.StatusServiceUnavailable. Use http.StatusGatewayTimeout for a route that is waiting on another server.
+	StatusCode int
+	// RetryAfter is the amount of time the browser is told to wait before trying again.
+	// It defaults to Timeout.
+	RetryAfter time.Duration
+}
+
+var deadlinesMu sync.RWMutex
+var deadlines = make(map[string]RouteDeadline)
+
+// RegisterDeadline sets the deadline for the requests served by the handler registered with pattern.
+// pattern must be the same as the pattern given to RegisterAppHandler, RegisterStaticHandler or RegisterDrawFunc.
+//
+// When the deadline passes, the context of the request is cancelled. Handlers that pass the context on to
+// the things they wait for, like database queries, will see the error and can return early. Once the handler
+// returns, or panics, the output it buffered is thrown away and a clean error is sent in its place.
+// The deadline cannot interrupt a handler that does not check its context.
+//
+// Handlers registered with RegisterStaticHandler do not have buffered output, so the error can only be sent if
+// the handler has not started writing. The same is true of handlers that call DisableOutputBuffering.
+//
+// If Timeout is longer than config.WriteTimeout, the write deadline of the connection is extended for the request,
+// so that a long download on one route does not require a long WriteTimeout for all of them.
+//
+// You may call this from an init() function.
+func RegisterDeadline(pattern string, d RouteDeadline) {
+	if d.Timeout <= 0 {
+		panic("the timeout of a deadline must be greater than zero")
+	}
+	deadlinesMu.Lock()
+	defer deadlinesMu.Unlock()
+	deadlines[joinProxyPath(pattern)] = d
+}
+
+// deadlineFor returns the deadline of the pattern that mux will use to serve r.
+func deadlineFor(mux Muxer, r *http.Request) (RouteDeadline, string, bool) {
+	deadlinesMu.RLock()
+	defer deadlinesMu.RUnlock()
+	if len(deadlines) == 0 {
+		return RouteDeadline{}, "", false
+	}
+	_, pattern := mux.Handler(r)
+	d, ok := deadlines[pattern]
+	return d, pattern, ok
+}
+
+// WithAppDeadlines is middleware that applies the deadlines registered with RegisterDeadline to the
+// routes of the AppMuxer. It must come after WithBufferedOutput, so that it can replace the output.
+func WithAppDeadlines(next http.Handler) http.Handler {
+	return withDeadlines(AppMuxer, next)
+}
+
+// WithStaticDeadlines is middleware that applies the deadlines registered with RegisterDeadline to the
+// routes of the PatternMuxer. It must come before WithPatternMuxer.
+func WithStaticDeadlines(next http.Handler) http.Handler {
+	return withDeadlines(PatternMuxer, next)
+}
+
+func withDeadlines(mux Muxer, next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		d, pattern, ok := deadlineFor(mux, r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), d.Timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		bw, buffered := w.(*bufferedResponseWriter)
+		if config.WriteTimeout > 0 && d.Timeout >= config.WriteTimeout {
+			base := w
+			if buffered {
+				base = bw.ResponseWriter
+			}
+			// Leave some time to send the error if the deadline passes
+			_ = http.NewResponseController(base).SetWriteDeadline(time.Now().Add(d.Timeout + 5*time.Second))
+		}
+		// The headers set before the handler, like the security headers, are sent with the error, but not the
+		// ones set by the handler, like its cookies.
+		header := w.Header().Clone()
+		var tw *trackingWriter
+		if !buffered {
+			tw = &trackingWriter{ResponseWriter: w}
+			w = tw
+		}
+
+		defer func() {
+			rec := recover()
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				if rec != nil {
+					panic(rec)
+				}
+				return
+			}
+			log.Info(ctx, logModule, "Request deadline passed",
+				slog.String("pattern", pattern),
+				slog.String("path", r.URL.Path),
+				slog.Duration("timeout", d.Timeout),
+				slog.Any("panic", rec))
+			switch {
+			case buffered && (!bw.disabled || bw.len == 0):
+				bw.buf.Reset()
+				bw.code = 0
+				resetHeader(bw.Header(), header)
+				d.sendError(bw)
+			case tw != nil && !tw.wrote:
+				resetHeader(tw.Header(), header)
+				d.sendError(tw.ResponseWriter)
+			default:
+				log.Warn(ctx, logModule, "Request deadline passed after the output was sent",
+					slog.String("path", r.URL.Path))
+			}
+		}()
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// sendError sends the error for a request whose deadline has passed.
+func (d RouteDeadline) sendError(w http.ResponseWriter) {
+	code := d.StatusCode
+	if code == 0 {
+		code = http.StatusServiceUnavailable
+	}
+	retry := d.RetryAfter
+	if retry == 0 {
+		retry = d.Timeout
+	}
+	h := w.Header()
+	h.Del("Content-Encoding")
+	h.Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+	http.Error(w, http.StatusText(code), code)
+}
+
+// resetHeader replaces the values of h with the values of saved.
+func resetHeader(h, saved http.Header) {
+	clear(h)
+	for k, v := range saved {
+		h[k] = v
+	}
+}
+
+// trackingWriter records whether anything has been written to a ResponseWriter.
+type trackingWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (t *trackingWriter) WriteHeader(code int) {
+	t.wrote = true
+	t.ResponseWriter.WriteHeader(code)
+}
+
+func (t *trackingWriter) Write(b []byte) (int, error) {
+	t.wrote = true
+	return t.ResponseWriter.Write(b)
+}
+
+// Flush lets handlers that stream their output flush it through the deadline handler.
+func (t *trackingWriter) Flush() {
+	t.wrote = true
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the websocket server take over the connection through the deadline handler.
+func (t *trackingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := t.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("the ResponseWriter does not support Hijack")
+	}
+	t.wrote = true
+	return h.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the writer underneath.
+func (t *trackingWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
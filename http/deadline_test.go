@@ -0,0 +1,161 @@
+package http
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goradd/serve/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	log.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)), "")
+}
+
+// slowHandler writes some output and then waits for its context to be done, or for wait to pass.
+func slowHandler(wait time.Duration, panicOnTimeout bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "report=1")
+		_, _ = io.WriteString(w, `{"partial":`)
+		select {
+		case <-r.Context().Done():
+			if panicOnTimeout {
+				panic(r.Context().Err())
+			}
+		case <-time.After(wait):
+			_, _ = io.WriteString(w, `true}`)
+		}
+	})
+}
+
+func TestWithAppDeadlines(t *testing.T) {
+	clearGlobals()
+	tests := []struct {
+		name       string
+		deadline   RouteDeadline
+		handler    http.Handler
+		wantCode   int
+		wantRetry  string
+		wantBody   string
+		wantCancel bool
+	}{
+		{"in time", RouteDeadline{Timeout: time.Second}, slowHandler(0, false),
+			http.StatusOK, "", `{"partial":true}`, false},
+		{"late", RouteDeadline{Timeout: 10 * time.Millisecond}, slowHandler(time.Second, false),
+			http.StatusServiceUnavailable, "1", "Service Unavailable\n", true},
+		{"late panic", RouteDeadline{Timeout: 10 * time.Millisecond}, slowHandler(time.Second, true),
+			http.StatusServiceUnavailable, "1", "Service Unavailable\n", true},
+		{"gateway", RouteDeadline{Timeout: 10 * time.Millisecond, StatusCode: http.StatusGatewayTimeout, RetryAfter: 90 * time.Second},
+			slowHandler(time.Second, false),
+			http.StatusGatewayTimeout, "90", "Gateway Timeout\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			AppMuxer = http.NewServeMux()
+			deadlines = make(map[string]RouteDeadline)
+			defer func() { deadlines = make(map[string]RouteDeadline) }()
+			RegisterAppHandler("/api/", tt.handler)
+			RegisterDeadline("/api/", tt.deadline)
+
+			h := WithBufferedOutput(WithAppDeadlines(WithAppMuxer(http.NotFoundHandler())))
+			w := httptest.NewRecorder()
+			w.Header().Set("X-Frame-Options", "DENY")
+			start := time.Now()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/api/report", nil))
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Equal(t, tt.wantRetry, w.Header().Get("Retry-After"))
+			assert.Equal(t, tt.wantBody, w.Body.String())
+			if tt.wantCancel {
+				assert.Less(t, time.Since(start), time.Second)
+				assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+				assert.Empty(t, w.Header().Get("Set-Cookie"), "the headers of the handler are not sent with the error")
+			}
+			assert.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+		})
+	}
+}
+
+func TestWithStaticDeadlines(t *testing.T) {
+	clearGlobals()
+	PatternMuxer = http.NewServeMux()
+	deadlines = make(map[string]RouteDeadline)
+	defer func() { deadlines = make(map[string]RouteDeadline) }()
+	RegisterStaticHandler("/quiet", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/elsewhere")
+		<-r.Context().Done()
+	}))
+	RegisterStaticHandler("/loud", slowHandler(time.Second, false))
+	RegisterStaticHandler("/free", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := r.Context().Deadline()
+		assert.False(t, ok)
+	}))
+	RegisterDeadline("/quiet", RouteDeadline{Timeout: 10 * time.Millisecond})
+	RegisterDeadline("/loud", RouteDeadline{Timeout: 10 * time.Millisecond})
+
+	h := WithStaticDeadlines(WithPatternMuxer(http.NotFoundHandler()))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/quiet", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Empty(t, w.Header().Get("Location"))
+
+	// Output has already been sent, so it cannot be replaced
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/loud", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"partial":`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/free", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestWithDeadlines_OtherPanic(t *testing.T) {
+	clearGlobals()
+	AppMuxer = http.NewServeMux()
+	deadlines = make(map[string]RouteDeadline)
+	defer func() { deadlines = make(map[string]RouteDeadline) }()
+	RegisterAppHandler("/bad", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(context.Canceled)
+	}))
+	RegisterDeadline("/bad", RouteDeadline{Timeout: time.Second})
+	h := WithAppDeadlines(WithAppMuxer(http.NotFoundHandler()))
+	assert.Panics(t, func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/bad", nil))
+	}, "panics before the deadline are passed on")
+}
+
+func TestWithStaticDeadlines_Flush(t *testing.T) {
+	clearGlobals()
+	PatternMuxer = http.NewServeMux()
+	deadlines = make(map[string]RouteDeadline)
+	defer func() { deadlines = make(map[string]RouteDeadline) }()
+	RegisterStaticHandler("/events", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := w.(http.Hijacker)
+		assert.True(t, ok, "websocket servers can hijack the connection")
+		f, ok := w.(http.Flusher)
+		if !assert.True(t, ok) {
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		f.Flush()
+		_, _ = io.WriteString(w, "data: 1\n\n")
+		f.Flush()
+		<-r.Context().Done()
+	}))
+	RegisterDeadline("/events", RouteDeadline{Timeout: 10 * time.Millisecond})
+
+	h := WithStaticDeadlines(WithPatternMuxer(http.NotFoundHandler()))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/events", nil))
+	assert.True(t, w.Flushed)
+	assert.Equal(t, http.StatusOK, w.Code, "the stream was sent, so the deadline error is not")
+	assert.Equal(t, "data: 1\n\n", w.Body.String())
+}
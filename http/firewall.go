@@ -0,0 +1,203 @@
+package http
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/goradd/serve/config"
+	"github.com/goradd/serve/log"
+	"github.com/goradd/serve/metrics"
+	strings2 "github.com/goradd/strings"
+)
+
+var firewallViolations = metrics.NewCounter("goradd_http_firewall_violations_total",
+	"Requests that broke a rule of the request validator, by rule.", "rule")
+
+// maxLoggedPathLength limits the size of the paths written to the log by the request validator.
+const maxLoggedPathLength = 256
+
+// violation is a rule of the request validator broken by a request.
+type violation struct {
+	rule   string
+	detail string
+	status int
+}
+
+// firewall holds the rules of the request validator, read from the config package.
+type firewall struct {
+	maxHeaderCount int
+	maxHeaderSize  int
+	maxURLLength   int
+	methods        map[string]bool
+	allow          string
+	hosts          []string
+	monitorOnly    bool
+}
+
+func newFirewall() *firewall {
+	f := &firewall{
+		maxHeaderCount: config.MaxHeaderCount,
+		maxHeaderSize:  config.MaxHeaderSize,
+		maxURLLength:   config.MaxURLLength,
+		monitorOnly:    config.FirewallMonitorOnly,
+	}
+	if len(config.AllowedMethods) > 0 {
+		f.methods = make(map[string]bool)
+		for _, m := range config.AllowedMethods {
+			f.methods[strings.ToUpper(m)] = true
+		}
+		f.allow = strings.Join(config.AllowedMethods, ", ")
+	}
+	for _, h := range config.AllowedHosts {
+		f.hosts = append(f.hosts, strings.ToLower(h))
+	}
+	return f
+}
+
+// WithHeaderValidator is middleware that rejects requests that are malformed, too large, or look like attacks,
+// before any handler sees them. It checks that:
+//   - the header names and values are ASCII,
+//   - the number of header lines, the size of the headers and the length of the url are within
+//     config.MaxHeaderCount, config.MaxHeaderSize and config.MaxURLLength,
+//   - the method is one of config.AllowedMethods, and the host is one of config.AllowedHosts,
+//   - the request does not have conflicting Content-Length headers, which are used to smuggle requests past proxies.
+//     The server already rejects these in HTTP/1 requests, along with Transfer-Encoding headers it does not know,
+//     and ignores Content-Length in chunked requests, so this catches the HTTP/2 requests that have them,
+//   - the path does not climb out of its directory with "..", even when encoded, and the url has no encoded nulls.
+//
+// Each broken rule is logged at the Warn level with the name of the rule, and counted in the metrics package.
+// If config.FirewallMonitorOnly is on, the requests are let through anyway.
+//
+// The default limits reject requests that were served before it was added: the ones that use methods
+// like TRACE, CONNECT or WebDAV methods, and the ones with more than 100 header lines or 32 KiB of headers.
+// Change config.AllowedMethods, config.MaxHeaderCount and config.MaxHeaderSize if your application needs them,
+// or turn on config.FirewallMonitorOnly to find out first.
+func WithHeaderValidator(next http.Handler) http.Handler {
+	f := newFirewall()
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		violations := f.check(r)
+		if len(violations) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		p := r.URL.Path
+		if len(p) > maxLoggedPathLength {
+			p = p[:maxLoggedPathLength]
+		}
+		for _, v := range violations {
+			firewallViolations.Inc(v.rule)
+			log.Warn(ctx, logModule, "Request broke a firewall rule",
+				slog.String("rule", v.rule),
+				slog.String("detail", v.detail),
+				slog.String("method", r.Method),
+				slog.String("path", p),
+				slog.String("remote_addr", r.RemoteAddr),
+				slog.Bool("monitor_only", f.monitorOnly))
+		}
+		if f.monitorOnly {
+			next.ServeHTTP(w, r)
+			return
+		}
+		v := violations[0]
+		if v.status == http.StatusMethodNotAllowed {
+			w.Header().Set("Allow", f.allow)
+		}
+		http.Error(w, http.StatusText(v.status), v.status)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// check returns the rules broken by r.
+func (f *firewall) check(r *http.Request) (violations []violation) {
+	add := func(rule string, status int, format string, args ...any) {
+		violations = append(violations, violation{rule, fmt.Sprintf(format, args...), status})
+	}
+
+	if f.methods != nil && !f.methods[r.Method] {
+		add("method", http.StatusMethodNotAllowed, "method %q is not allowed", r.Method)
+	}
+	if f.hosts != nil && !f.allowsHost(r.Host) {
+		add("host", http.StatusBadRequest, "host %q is not allowed", r.Host)
+	}
+
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	if f.maxURLLength > 0 && len(uri) > f.maxURLLength {
+		add("url_length", http.StatusRequestURITooLong, "url is %d bytes", len(uri))
+	}
+
+	count, size := 0, len(r.Host)
+	for k, values := range r.Header {
+		for _, v := range values {
+			count++
+			size += len(k) + len(v)
+		}
+		if !strings2.IsASCII(k) {
+			add("ascii", http.StatusBadRequest, "header name %q is not ASCII", k)
+			continue
+		}
+		for _, v := range values {
+			if !strings2.IsASCII(v) {
+				add("ascii", http.StatusBadRequest, "value of header %q is not ASCII", k)
+				break
+			}
+		}
+	}
+	if f.maxHeaderCount > 0 && count > f.maxHeaderCount {
+		add("header_count", http.StatusRequestHeaderFieldsTooLarge, "request has %d header lines", count)
+	}
+	if f.maxHeaderSize > 0 && size > f.maxHeaderSize {
+		add("header_size", http.StatusRequestHeaderFieldsTooLarge, "headers are %d bytes", size)
+	}
+
+	lengths := r.Header.Values("Content-Length")
+	for _, l := range lengths[min(len(lengths), 1):] {
+		if strings.TrimSpace(l) != strings.TrimSpace(lengths[0]) {
+			add("content_length", http.StatusBadRequest, "request has conflicting Content-Length headers")
+			break
+		}
+	}
+
+	if hasTraversal(r.URL.Path) {
+		add("path_traversal", http.StatusBadRequest, "path climbs out of its directory")
+	}
+	if strings.Contains(r.URL.Path, "\x00") || strings.Contains(strings.ToLower(uri), "%00") {
+		add("null", http.StatusBadRequest, "url has an encoded null")
+	}
+	return
+}
+
+// allowsHost returns true if the host name in host, which may have a port, is one of the allowed hosts.
+func (f *firewall) allowsHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	for _, a := range f.hosts {
+		if a == host {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(a, "*"); ok && len(host) > len(suffix) && strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasTraversal returns true if the decoded path p has a ".." segment, counting backslashes as slashes, and
+// dots and slashes that are still encoded, which is how double encoded paths get past a single decoding.
+func hasTraversal(p string) bool {
+	p = strings.NewReplacer("%2e", ".", "%2f", "/", "%5c", "/", "\\", "/").Replace(strings.ToLower(p))
+	for _, s := range strings.Split(p, "/") {
+		if s == ".." {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goradd/serve/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/http2/hpack"
+)
+
+func TestWithHeaderValidator(t *testing.T) {
+	clearGlobals()
+	config.MaxHeaderCount = 5
+	config.MaxURLLength = 100
+	config.AllowedHosts = []string{"example.com", "*.example.org"}
+	defer func() {
+		config.MaxHeaderCount = 100
+		config.MaxURLLength = 8 * 1024
+		config.AllowedHosts = nil
+	}()
+
+	var called bool
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true })
+	h := WithHeaderValidator(ok)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		host   string
+		header map[string]string
+		want   int
+	}{
+		{"good", "GET", "/a/b?c=d", "", nil, http.StatusOK},
+		{"subdomain", "GET", "/", "a.example.org:8080", nil, http.StatusOK},
+		{"method", "TRACE", "/", "", nil, http.StatusMethodNotAllowed},
+		{"host", "GET", "/", "evil.com", nil, http.StatusBadRequest},
+		{"bare wildcard host", "GET", "/", "example.org", nil, http.StatusBadRequest},
+		{"url length", "GET", "/" + strings.Repeat("a", 100), "", nil, http.StatusRequestURITooLong},
+		{"header count", "GET", "/", "", map[string]string{"A": "1", "B": "2", "C": "3", "D": "4", "E": "5", "F": "6"}, http.StatusRequestHeaderFieldsTooLarge},
+		{"ascii", "GET", "/", "", map[string]string{"A": "é"}, http.StatusBadRequest},
+		{"traversal", "GET", "/a/../../etc/passwd", "", nil, http.StatusBadRequest},
+		{"double encoded traversal", "GET", "/a/%252e%252e/b", "", nil, http.StatusBadRequest},
+		{"backslash traversal", "GET", "/a/..%5cb", "", nil, http.StatusBadRequest},
+		{"dots in name", "GET", "/a/b..c/", "", nil, http.StatusOK},
+		{"null", "GET", "/a%00.html", "", nil, http.StatusBadRequest},
+		{"null in query", "GET", "/a?b=%00", "", nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called = false
+			r := httptest.NewRequest(tt.method, "http://example.com"+tt.target, nil)
+			r.RequestURI = tt.target
+			if tt.host != "" {
+				r.Host = tt.host
+			}
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			assert.Equal(t, tt.want, w.Code)
+			assert.Equal(t, tt.want == http.StatusOK, called)
+			if tt.want == http.StatusMethodNotAllowed {
+				assert.Contains(t, w.Header().Get("Allow"), "GET")
+			}
+		})
+	}
+}
+
+func TestWithHeaderValidator_MonitorOnly(t *testing.T) {
+	clearGlobals()
+	config.FirewallMonitorOnly = true
+	defer func() { config.FirewallMonitorOnly = false }()
+
+	var called bool
+	h := WithHeaderValidator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+	before := firewallViolations.Value("path_traversal")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/a/../../b", nil))
+	assert.True(t, called, "the request is let through")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, before+1, firewallViolations.Value("path_traversal"), "the violation is still counted")
+}
+
+// TestWithHeaderValidator_ContentLength sends raw requests to a server, since the requests with bad lengths that
+// the validator sees are the ones that get through the parsers of the server.
+func TestWithHeaderValidator_ContentLength(t *testing.T) {
+	clearGlobals()
+	var called bool
+	h := WithHeaderValidator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+	s := httptest.NewServer(h2c.NewHandler(h, &http2.Server{}))
+	defer s.Close()
+
+	t.Run("http/1", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			header string
+			want   int
+			called bool
+		}{
+			{"cl and te", "Content-Length: 5\r\nTransfer-Encoding: chunked\r\n", http.StatusOK, true}, // the server drops Content-Length
+			{"conflicting cl", "Content-Length: 0\r\nContent-Length: 5\r\n", http.StatusBadRequest, false},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				called = false
+				c, err := net.Dial("tcp", s.Listener.Addr().String())
+				require.NoError(t, err)
+				defer c.Close()
+				_, err = c.Write([]byte("POST / HTTP/1.1\r\nHost: x\r\n" + tt.header + "\r\n0\r\n\r\n"))
+				require.NoError(t, err)
+				resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, resp.StatusCode)
+				assert.Equal(t, tt.called, called)
+			})
+		}
+	})
+
+	t.Run("http/2 conflicting cl", func(t *testing.T) {
+		called = false
+		before := firewallViolations.Value("content_length")
+		c, err := net.Dial("tcp", s.Listener.Addr().String())
+		require.NoError(t, err)
+		defer c.Close()
+		_, err = c.Write([]byte(http2.ClientPreface))
+		require.NoError(t, err)
+		fr := http2.NewFramer(c, c)
+		require.NoError(t, fr.WriteSettings())
+		var buf bytes.Buffer
+		enc := hpack.NewEncoder(&buf)
+		for _, f := range [][2]string{{":method", "POST"}, {":scheme", "http"}, {":authority", "x"}, {":path", "/"},
+			{"content-length", "0"}, {"content-length", "5"}} {
+			require.NoError(t, enc.WriteField(hpack.HeaderField{Name: f[0], Value: f[1]}))
+		}
+		require.NoError(t, fr.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: buf.Bytes(), EndStream: true, EndHeaders: true}))
+		for {
+			f, err := fr.ReadFrame()
+			require.NoError(t, err)
+			if hf, ok := f.(*http2.HeadersFrame); ok {
+				fields, err := hpack.NewDecoder(4096, nil).DecodeFull(hf.HeaderBlockFragment())
+				require.NoError(t, err)
+				assert.Equal(t, "400", fields[0].Value)
+				break
+			}
+		}
+		assert.False(t, called)
+		assert.Equal(t, before+1, firewallViolations.Value("content_length"))
+	})
+}
@@ -60,15 +60,3 @@ func ValidateHeader(header http.Header) bool {
 	}
 	return true
 }
-
-// WithHeaderValidator insert middleware into the handler stack that performs OWASP style validation on a request.
-func WithHeaderValidator(next http.Handler) http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		if !ValidateHeader(r.Header) {
-			w.WriteHeader(http.StatusBadRequest)
-		} else {
-			next.ServeHTTP(w, r)
-		}
-	}
-	return http.HandlerFunc(fn)
-}
@@ -0,0 +1,251 @@
+package http
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/goradd/serve/config"
+	"github.com/goradd/serve/log"
+)
+
+// Priority is the class of a request when it is waiting in the queue of a limiter.
+// Waiting requests with a higher priority are let in first.
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+	// PriorityExempt requests skip the limits altogether.
+	PriorityExempt
+)
+
+// LimitCounts is a snapshot of the state of a limiter.
+type LimitCounts struct {
+	// InFlight is the number of requests being served.
+	InFlight int
+	// Queued is the number of requests waiting to be served.
+	Queued int
+	// Rejected is the total number of requests that were turned away because the queue was full,
+	// or because they waited too long.
+	Rejected int
+}
+
+// limiter lets up to max requests in at a time, and keeps up to maxQueue more waiting for a turn.
+type limiter struct {
+	mu       sync.Mutex
+	max      int
+	maxQueue int
+	inFlight int
+	rejected int
+	// waiting holds a queue of waiters for each priority below PriorityExempt.
+	waiting [PriorityExempt][]chan struct{}
+}
+
+func newLimiter(max, maxQueue int) *limiter {
+	return &limiter{max: max, maxQueue: maxQueue}
+}
+
+func (l *limiter) queued() (n int) {
+	for _, q := range l.waiting {
+		n += len(q)
+	}
+	return
+}
+
+// acquire waits for a turn to serve a request. It returns false if the queue is full,
+// or if ctx is done before a turn comes up.
+func (l *limiter) acquire(ctx context.Context, p Priority) bool {
+	l.mu.Lock()
+	if l.inFlight < l.max && l.queued() == 0 {
+		l.inFlight++
+		l.mu.Unlock()
+		return true
+	}
+	if l.queued() >= l.maxQueue {
+		l.rejected++
+		l.mu.Unlock()
+		return false
+	}
+	ch := make(chan struct{})
+	l.waiting[p] = append(l.waiting[p], ch)
+	l.mu.Unlock()
+
+	select {
+	case <-ch:
+		return true
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if i := slices.Index(l.waiting[p], ch); i >= 0 {
+		l.waiting[p] = slices.Delete(l.waiting[p], i, i+1)
+		l.rejected++
+		return false
+	}
+	// release handed us a turn just as we gave up
+	return true
+}
+
+// release ends a turn, handing it to the waiter with the highest priority if there is one.
+func (l *limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for p := len(l.waiting) - 1; p >= 0; p-- {
+		if len(l.waiting[p]) > 0 {
+			ch := l.waiting[p][0]
+			l.waiting[p] = slices.Delete(l.waiting[p], 0, 1)
+			close(ch) // the turn passes straight to the waiter, so inFlight does not change
+			return
+		}
+	}
+	l.inFlight--
+}
+
+func (l *limiter) counts() LimitCounts {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return LimitCounts{InFlight: l.inFlight, Queued: l.queued(), Rejected: l.rejected}
+}
+
+type routeLimit struct {
+	group    string
+	priority Priority
+}
+
+var limitsMu sync.RWMutex
+var globalLimiter *limiter
+var limitGroups = make(map[string]*limiter)
+var routeLimits = make(map[string]routeLimit)
+
+// RegisterLimitGroup creates a named group of routes that share a limit of maxInFlight requests being served at a time,
+// with up to maxQueue more requests waiting for a turn. Use RegisterRouteLimit to put routes in the group.
+//
+// Groups let you keep an expensive part of the application, like report generation, from using up all of
+// the global limit set by config.MaxRequestsInFlight. You may call this from an init() function.
+func RegisterLimitGroup(name string, maxInFlight, maxQueue int) {
+	if name == "" || maxInFlight <= 0 || maxQueue < 0 {
+		panic("a limit group needs a name and a maximum greater than zero")
+	}
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+	limitGroups[name] = newLimiter(maxInFlight, maxQueue)
+}
+
+// RegisterRouteLimit sets the limit group and priority of the route registered with pattern. pattern must be the
+// same as the pattern given to RegisterAppHandler, RegisterStaticHandler or RegisterDrawFunc.
+// group may be blank to only apply the global limit.
+//
+// By default, the routes of the PatternMuxer, which serve static files, websockets and the health endpoints,
+// are PriorityExempt, and all other requests are PriorityNormal and in no group.
+// You may call this from an init() function.
+func RegisterRouteLimit(pattern string, group string, p Priority) {
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+	if _, ok := limitGroups[group]; group != "" && !ok {
+		panic("limit group " + group + " has not been registered")
+	}
+	routeLimits[joinProxyPath(pattern)] = routeLimit{group, p}
+}
+
+// Limits returns the current counts of the limiters. The global limiter is under the blank name,
+// and is only present once WithLimits has been put in the handler stack.
+func Limits() map[string]LimitCounts {
+	limitsMu.RLock()
+	defer limitsMu.RUnlock()
+	m := make(map[string]LimitCounts, len(limitGroups)+1)
+	if globalLimiter != nil {
+		m[""] = globalLimiter.counts()
+	}
+	for name, l := range limitGroups {
+		m[name] = l.counts()
+	}
+	return m
+}
+
+// routeLimitFor returns the limit group and priority of a request.
+func routeLimitFor(r *http.Request) routeLimit {
+	limitsMu.RLock()
+	defer limitsMu.RUnlock()
+	if _, p := PatternMuxer.Handler(r); p != "" {
+		if rl, ok := routeLimits[p]; ok {
+			return rl
+		}
+		return routeLimit{priority: PriorityExempt}
+	}
+	if len(routeLimits) > 0 {
+		if _, p := AppMuxer.Handler(r); p != "" {
+			if rl, ok := routeLimits[p]; ok {
+				return rl
+			}
+		}
+	}
+	return routeLimit{priority: PriorityNormal}
+}
+
+// WithLimits is middleware that limits the number of requests being served at a time, so that a traffic spike
+// cannot use up all the memory of the server.
+//
+// There is a global limit of config.MaxRequestsInFlight, and the limits of the groups made with RegisterLimitGroup.
+// Requests beyond a limit wait in a queue of up to config.MaxRequestQueue requests, for up to config.RequestQueueTimeout.
+// Requests that do not fit in the queue, or wait too long, are quickly answered with a 503 Service Unavailable error.
+// See RegisterRouteLimit for how requests are assigned to groups and priorities.
+//
+// It should come before WithBufferedOutput, so that waiting requests do not hold on to an output buffer.
+func WithLimits(next http.Handler) http.Handler {
+	var global *limiter
+	if config.MaxRequestsInFlight > 0 {
+		global = newLimiter(config.MaxRequestsInFlight, config.MaxRequestQueue)
+	}
+	limitsMu.Lock()
+	globalLimiter = global
+	limitsMu.Unlock()
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		rl := routeLimitFor(r)
+		if rl.priority == PriorityExempt {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var group *limiter
+		if rl.group != "" {
+			limitsMu.RLock()
+			group = limitGroups[rl.group]
+			limitsMu.RUnlock()
+		}
+		if global == nil && group == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		if config.RequestQueueTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, config.RequestQueueTimeout)
+			defer cancel()
+		}
+		// Wait for the group first, so that requests waiting for a busy group do not hold a global turn
+		for _, l := range []*limiter{group, global} {
+			if l == nil {
+				continue
+			}
+			if !l.acquire(ctx, rl.priority) {
+				if r.Context().Err() == nil { // the browser is still waiting
+					log.Debug(ctx, logModule, "Server busy, rejected request",
+						slog.String("path", r.URL.Path),
+						slog.String("group", rl.group))
+					w.Header().Set("Retry-After", "1")
+					http.Error(w, "The server is too busy. Please try again.", http.StatusServiceUnavailable)
+				}
+				return
+			}
+			defer l.release()
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,97 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goradd/serve/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter_Priority(t *testing.T) {
+	l := newLimiter(1, 10)
+	ctx := context.Background()
+	require.True(t, l.acquire(ctx, PriorityNormal))
+
+	var mu sync.Mutex
+	var order []Priority
+	var wg sync.WaitGroup
+	for _, p := range []Priority{PriorityLow, PriorityNormal, PriorityHigh} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if l.acquire(ctx, p) {
+				mu.Lock()
+				order = append(order, p)
+				mu.Unlock()
+				l.release()
+			}
+		}()
+		// let each one get in the queue before the next
+		assert.Eventually(t, func() bool { return l.counts().Queued == int(p)+1 }, time.Second, time.Millisecond)
+	}
+	l.release()
+	wg.Wait()
+	assert.Equal(t, []Priority{PriorityHigh, PriorityNormal, PriorityLow}, order)
+	assert.Equal(t, LimitCounts{}, l.counts())
+}
+
+func TestLimiter_Reject(t *testing.T) {
+	l := newLimiter(1, 1)
+	require.True(t, l.acquire(context.Background(), PriorityNormal))
+
+	// The queue has room for one, which times out
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	done := make(chan bool)
+	go func() { done <- l.acquire(ctx, PriorityNormal) }()
+	assert.Eventually(t, func() bool { return l.counts().Queued == 1 }, time.Second, time.Millisecond)
+
+	// The queue is full
+	assert.False(t, l.acquire(context.Background(), PriorityHigh))
+	assert.False(t, <-done)
+	assert.Equal(t, LimitCounts{InFlight: 1, Rejected: 2}, l.counts())
+}
+
+func TestWithLimits(t *testing.T) {
+	clearGlobals()
+	config.MaxRequestsInFlight = 1
+	config.MaxRequestQueue = 0
+	defer func() {
+		config.MaxRequestsInFlight = 0
+		config.MaxRequestQueue = 100
+	}()
+	PatternMuxer = http.NewServeMux()
+	AppMuxer = http.NewServeMux()
+
+	release := make(chan struct{})
+	entered := make(chan struct{})
+	RegisterAppHandler("/slow", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	}))
+	RegisterStaticHandler("/healthz", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	h := WithLimits(WithPatternMuxer(WithAppMuxer(http.NotFoundHandler())))
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	<-entered
+	assert.Equal(t, 1, Limits()[""].InFlight)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/other", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "static routes skip the limits")
+
+	close(release)
+	assert.Eventually(t, func() bool { return Limits()[""].InFlight == 0 }, time.Second, time.Millisecond)
+	assert.Equal(t, 1, Limits()[""].Rejected)
+}
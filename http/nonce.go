@@ -0,0 +1,142 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/goradd/serve/config"
+	"github.com/goradd/serve/log"
+	"github.com/goradd/serve/parse"
+)
+
+type cspNonceContext struct{}
+
+// CSPNonce returns the nonce of the Content-Security-Policy of the request, or an empty string if there is none.
+//
+// Templates that write their own inline scripts and styles should put it in the nonce attribute of those tags
+// as they render them. Only those nonces protect against HTML injection, since WithCSPNonce adds the nonce to
+// injected tags too. It is also for code that makes tags some other way, like in javascript,
+// or that sends output that is not buffered.
+func CSPNonce(ctx context.Context) string {
+	n, _ := ctx.Value(cspNonceContext{}).(string)
+	return n
+}
+
+// WithCSPNonce is middleware that lets the inline scripts and styles of a page run under a strict
+// Content-Security-Policy, when config.CSPNonce is on.
+//
+// It makes a random nonce for each request and puts it in the context, see CSPNonce. The nonce is added
+// to the script-src and style-src directives of the Content-Security-Policy headers already set on
+// the response, and after the rest of the handlers are done, a nonce attribute is added to
+// each <script> and <style> tag of the buffered html that does not have one, see parse.AddNonce.
+//
+// Adding the nonce to the output gives no protection against HTML injection. A script that a handler echoed
+// without escaping it gets the nonce too, and runs, so the policy only limits where scripts may be loaded
+// from. For that protection, write the nonces in the templates with CSPNonce, and turn off config.CSPNonceTags
+// so that only the policy is changed. Tags with the parse.NoNonceAttribute never get a nonce.
+//
+// It must come after WithBufferedOutput, and after the stage that sets the Content-Security-Policy.
+// Output written after calling DisableOutputBuffering is not changed.
+func WithCSPNonce(next http.Handler) http.Handler {
+	if !config.CSPNonce {
+		return next
+	}
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		nonce := newCSPNonce()
+		ctx := context.WithValue(r.Context(), cspNonceContext{}, nonce)
+		for _, name := range []string{"Content-Security-Policy", "Content-Security-Policy-Report-Only"} {
+			if p := w.Header().Get(name); p != "" {
+				w.Header().Set(name, addNonceToPolicy(p, nonce))
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+		if !config.CSPNonceTags {
+			return
+		}
+
+		bw, ok := ctx.Value(bufferedOutputContext{}).(BufferedResponseWriterI)
+		if !ok || bw.Len() == 0 {
+			return
+		}
+		if br, ok := bw.(*bufferedResponseWriter); ok && br.disabled {
+			return
+		}
+		buf := bw.OutputBuffer()
+		ct := w.Header().Get("Content-Type")
+		if ct == "" {
+			ct = http.DetectContentType(buf.Bytes())
+		}
+		if !strings.HasPrefix(ct, "text/html") {
+			return
+		}
+		out, err := parse.AddNonce(buf.Bytes(), nonce)
+		if err != nil {
+			log.Warn(ctx, logModule, "Could not add nonces to the output", slog.Any("error", err))
+			return
+		}
+		buf.Reset()
+		buf.Write(out)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// newCSPNonce returns a random 128-bit nonce in base64.
+func newCSPNonce() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return base64.StdEncoding.EncodeToString(b[:])
+}
+
+// addNonceToPolicy adds the nonce to the script-src and style-src directives of the policy p.
+// If one of them is missing, it is made from default-src, which the browser would otherwise use in its place.
+// If there is no default-src either, scripts and styles are not limited, and nothing is added.
+//
+// The browser ignores 'unsafe-inline' in a directive that has a nonce.
+func addNonceToPolicy(p, nonce string) string {
+	source := "'nonce-" + nonce + "'"
+	directives := strings.Split(p, ";")
+	var defaultSrc []string
+	var hasDefault, hasScript, hasStyle bool
+	for i, d := range directives {
+		fields := strings.Fields(d)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToLower(fields[0]) {
+		case "default-src":
+			defaultSrc = fields[1:]
+			hasDefault = true
+		case "script-src":
+			directives[i] = " " + nonceDirective(fields[0], fields[1:], source)
+			hasScript = true
+		case "style-src":
+			directives[i] = " " + nonceDirective(fields[0], fields[1:], source)
+			hasStyle = true
+		}
+	}
+	if hasDefault {
+		if !hasScript {
+			directives = append(directives, " "+nonceDirective("script-src", defaultSrc, source))
+		}
+		if !hasStyle {
+			directives = append(directives, " "+nonceDirective("style-src", defaultSrc, source))
+		}
+	}
+	return strings.TrimSpace(strings.Join(directives, ";"))
+}
+
+// nonceDirective returns the directive with the given name and sources, with the nonce source added.
+// 'none' is removed, since it may only be used alone.
+func nonceDirective(name string, sources []string, source string) string {
+	parts := []string{name}
+	for _, s := range sources {
+		if !strings.EqualFold(s, "'none'") {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(append(parts, source), " ")
+}
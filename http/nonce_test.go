@@ -0,0 +1,71 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goradd/serve/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddNonceToPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		want   string
+	}{
+		{"script and style", "script-src 'self'; style-src 'self' 'unsafe-inline'",
+			"script-src 'self' 'nonce-n'; style-src 'self' 'unsafe-inline' 'nonce-n'"},
+		{"from default-src", "default-src 'self'; img-src *",
+			"default-src 'self'; img-src *; script-src 'self' 'nonce-n'; style-src 'self' 'nonce-n'"},
+		{"none", "default-src 'none'; script-src 'none'",
+			"default-src 'none'; script-src 'nonce-n'; style-src 'nonce-n'"},
+		{"not limited", "frame-ancestors 'self'", "frame-ancestors 'self'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, addNonceToPolicy(tt.policy, "n"))
+		})
+	}
+}
+
+func TestWithCSPNonce(t *testing.T) {
+	clearGlobals()
+	config.CSPNonce = true
+	defer func() { config.CSPNonce = false }()
+
+	var nonce string
+	page := func(ct, body string) http.Handler {
+		h := WithCSPNonce(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nonce = CSPNonce(r.Context())
+			if ct != "" {
+				w.Header().Set("Content-Type", ct)
+			}
+			_, _ = w.Write([]byte(body))
+		}))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Security-Policy", "script-src 'self'")
+			WithBufferedOutput(h).ServeHTTP(w, r)
+		})
+	}
+
+	w := httptest.NewRecorder()
+	page("", "<html><script>go()</script></html>").ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Len(t, nonce, 24)
+	assert.Equal(t, `<html><script nonce="`+nonce+`">go()</script></html>`, w.Body.String())
+	assert.Equal(t, "script-src 'self' 'nonce-"+nonce+"'", w.Header().Get("Content-Security-Policy"))
+	first := nonce
+
+	w = httptest.NewRecorder()
+	page("application/json", `"<script>"`).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.NotEqual(t, first, nonce, "each request has its own nonce")
+	assert.Equal(t, `"<script>"`, w.Body.String(), "only html is changed")
+
+	config.CSPNonceTags = false
+	defer func() { config.CSPNonceTags = true }()
+	w = httptest.NewRecorder()
+	page("", "<html><script>injected()</script></html>").ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, "<html><script>injected()</script></html>", w.Body.String(), "the tags are left to the templates")
+	assert.Equal(t, "script-src 'self' 'nonce-"+nonce+"'", w.Header().Get("Content-Security-Policy"))
+}
@@ -0,0 +1,175 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/goradd/serve/trace"
+)
+
+// Middleware wraps a handler with another handler, forming one stage of a handler stack.
+type Middleware func(next http.Handler) http.Handler
+
+// Stage is a named Middleware in a Pipeline.
+type Stage struct {
+	Name       string
+	Middleware Middleware
+}
+
+// orderRule requires one stage to come before another if both are in the pipeline.
+type orderRule struct {
+	first  string
+	second string
+	reason string
+}
+
+// Pipeline builds a handler stack out of named stages.
+//
+// Stages are kept in the order a request visits them, so the first stage is the outermost handler,
+// and the last stage is the one that calls the final handler passed to Build.
+// Naming the stages lets an application insert its own middleware before or after a known stage,
+// or replace or remove a stage, without having to rebuild the whole stack.
+//
+// Rules added with Require are checked by Build, so that a customization that breaks the assumptions of
+// a stage is caught when the application starts.
+type Pipeline struct {
+	stages []Stage
+	rules  []orderRule
+}
+
+// NewPipeline returns a new Pipeline holding the given stages in order.
+func NewPipeline(stages ...Stage) *Pipeline {
+	p := new(Pipeline)
+	for _, s := range stages {
+		p.Append(s.Name, s.Middleware)
+	}
+	return p
+}
+
+// Append adds a stage to the end of the pipeline, so that it is the last to be called before the final handler.
+//
+// It will panic if a stage with the same name already exists.
+func (p *Pipeline) Append(name string, m Middleware) {
+	p.insert(len(p.stages), name, m)
+}
+
+// Prepend adds a stage to the start of the pipeline, so that it is the first to see a request.
+//
+// It will panic if a stage with the same name already exists.
+func (p *Pipeline) Prepend(name string, m Middleware) {
+	p.insert(0, name, m)
+}
+
+// InsertBefore adds a stage in front of the stage named before.
+//
+// It will panic if the stage named before does not exist, or if a stage with the same name already exists.
+func (p *Pipeline) InsertBefore(before string, name string, m Middleware) {
+	p.insert(p.mustFind(before), name, m)
+}
+
+// InsertAfter adds a stage just after the stage named after.
+//
+// It will panic if the stage named after does not exist, or if a stage with the same name already exists.
+func (p *Pipeline) InsertAfter(after string, name string, m Middleware) {
+	p.insert(p.mustFind(after)+1, name, m)
+}
+
+// Replace replaces the middleware of the named stage, keeping its position.
+//
+// It will panic if the stage does not exist.
+func (p *Pipeline) Replace(name string, m Middleware) {
+	if m == nil {
+		panic("middleware may not be nil")
+	}
+	p.stages[p.mustFind(name)].Middleware = m
+}
+
+// Remove removes the named stage. It does nothing if the stage does not exist.
+func (p *Pipeline) Remove(name string) {
+	if i := p.find(name); i >= 0 {
+		p.stages = slices.Delete(p.stages, i, i+1)
+	}
+}
+
+// Has returns true if the named stage is in the pipeline.
+func (p *Pipeline) Has(name string) bool {
+	return p.find(name) >= 0
+}
+
+// Names returns the names of the stages in the order a request visits them.
+func (p *Pipeline) Names() []string {
+	names := make([]string, len(p.stages))
+	for i, s := range p.stages {
+		names[i] = s.Name
+	}
+	return names
+}
+
+// Require adds a rule that the stage named first must come before the stage named second.
+// The rule only applies if both stages are in the pipeline when it is built.
+// reason is included in the error returned by Build if the rule is broken.
+func (p *Pipeline) Require(first, second string, reason string) {
+	p.rules = append(p.rules, orderRule{first, second, reason})
+}
+
+// Check returns an error if the order of the stages breaks any of the rules added with Require.
+func (p *Pipeline) Check() error {
+	for _, r := range p.rules {
+		i, j := p.find(r.first), p.find(r.second)
+		if i >= 0 && j >= 0 && i > j {
+			return fmt.Errorf("stage %q must come before stage %q: %s. The stages are: %s",
+				r.first, r.second, r.reason, strings.Join(p.Names(), ", "))
+		}
+	}
+	return nil
+}
+
+// Build checks the rules of the pipeline and returns the handler stack, ending with final.
+//
+// If a tracer has been set with trace.SetTracer, each stage is wrapped with a span named after the stage,
+// for the requests being traced by WithTracing.
+func (p *Pipeline) Build(final http.Handler) (http.Handler, error) {
+	if final == nil {
+		panic("final may not be nil. Pass a http.NotFoundHandler if there is nothing else to do")
+	}
+	if err := p.Check(); err != nil {
+		return nil, err
+	}
+	// the handler chain gets built in the reverse order of getting called
+	h := final
+	traced := trace.Enabled()
+	for i := len(p.stages) - 1; i >= 0; i-- {
+		h = p.stages[i].Middleware(h)
+		if traced {
+			h = traceStage(p.stages[i].Name, h)
+		}
+	}
+	return h, nil
+}
+
+func (p *Pipeline) insert(i int, name string, m Middleware) {
+	if name == "" {
+		panic("stage name may not be empty")
+	}
+	if m == nil {
+		panic("middleware may not be nil")
+	}
+	if p.find(name) >= 0 {
+		panic(fmt.Sprintf("stage %q is already in the pipeline", name))
+	}
+	p.stages = slices.Insert(p.stages, i, Stage{name, m})
+}
+
+func (p *Pipeline) find(name string) int {
+	return slices.IndexFunc(p.stages, func(s Stage) bool { return s.Name == name })
+}
+
+func (p *Pipeline) mustFind(name string) int {
+	i := p.find(name)
+	if i < 0 {
+		panic(fmt.Sprintf("stage %q is not in the pipeline", name))
+	}
+	return i
+}
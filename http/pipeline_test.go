@@ -0,0 +1,68 @@
+package http
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tagger returns middleware that writes its tag before calling the next handler.
+func tagger(tag string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, tag)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func runPipeline(t *testing.T, p *Pipeline) string {
+	h, err := p.Build(http.HandlerFunc(fnFound))
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	return w.Body.String()
+}
+
+func TestPipeline(t *testing.T) {
+	p := NewPipeline(
+		Stage{"a", tagger("a")},
+		Stage{"c", tagger("c")},
+	)
+	assert.Equal(t, "acFound", runPipeline(t, p))
+
+	p.InsertBefore("c", "b", tagger("b"))
+	p.InsertAfter("c", "d", tagger("d"))
+	p.Prepend("0", tagger("0"))
+	p.Append("e", tagger("e"))
+	assert.Equal(t, []string{"0", "a", "b", "c", "d", "e"}, p.Names())
+	assert.Equal(t, "0abcdeFound", runPipeline(t, p))
+
+	p.Replace("c", tagger("C"))
+	p.Remove("0")
+	p.Remove("x")
+	assert.False(t, p.Has("0"))
+	assert.Equal(t, "abCdeFound", runPipeline(t, p))
+
+	assert.Panics(t, func() { p.Append("a", tagger("a")) })
+	assert.Panics(t, func() { p.InsertBefore("x", "y", tagger("y")) })
+	assert.Panics(t, func() { p.Replace("x", tagger("x")) })
+}
+
+func TestPipeline_Require(t *testing.T) {
+	p := NewPipeline(
+		Stage{"a", tagger("a")},
+		Stage{"b", tagger("b")},
+	)
+	p.Require("a", "b", "a goes first")
+	p.Require("a", "x", "x is not in the pipeline")
+	assert.NoError(t, p.Check())
+
+	p.Remove("a")
+	p.Append("a", tagger("a"))
+	_, err := p.Build(http.HandlerFunc(fnFound))
+	assert.ErrorContains(t, err, "a goes first")
+}
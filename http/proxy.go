@@ -0,0 +1,336 @@
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/goradd/serve/config"
+)
+
+// forwardingHeaders are the headers read by WithTrustedProxies. They are removed from requests that do not come
+// from a trusted proxy, so that handlers further down the stack cannot be fooled by a client that sends them.
+var forwardingHeaders = []string{"Forwarded", "X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "X-Forwarded-Prefix"}
+
+// hop is what one proxy reported about the request it received.
+type hop struct {
+	addr   netip.Addr // not valid if the proxy hid the address
+	port   string
+	proto  string
+	host   string
+	prefix string
+}
+
+type forwardedPrefixContext struct{}
+
+// trustUnixPeers is the entry of config.TrustedProxies that trusts the peers of unix socket listeners.
+const trustUnixPeers = "unix"
+
+// ParseTrustedProxies parses a list of IP addresses and CIDR ranges, like config.TrustedProxies.
+// The "unix" entry is skipped, since it is not an address.
+func ParseTrustedProxies(list []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range list {
+		if s == trustUnixPeers {
+			continue
+		}
+		if p, err := netip.ParsePrefix(s); err == nil {
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, err
+		}
+		a = a.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(a, a.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// WithTrustedProxies is middleware that rewrites requests that come through the proxies listed in
+// config.TrustedProxies, so that the rest of the handler stack sees the request the client made,
+// rather than the request made by the proxy.
+//
+// It reads the RFC 7239 Forwarded header, or if there is none, the X-Forwarded-For, X-Forwarded-Proto,
+// X-Forwarded-Host and X-Forwarded-Prefix headers. The list of addresses is read from the end, skipping
+// the trusted proxies, and the first address that is not trusted becomes the RemoteAddr of the request.
+// The scheme and host reported by the proxy that received the request from the client are put in
+// r.URL.Scheme and r.Host, for handlers that make absolute urls. The path prefix is put in the context, see
+// ForwardedPrefix. Nothing else in the server reads them. In particular, the Secure flag of the session cookie
+// comes from the session manager, and Redirect and GetAssetUrl only add config.ProxyPath to their paths.
+//
+// Requests that come in on a unix socket listener, which have no peer address, are trusted if
+// config.TrustedProxies has a "unix" entry. The forwarding headers are removed from requests that do not
+// come from a trusted proxy.
+// It must come before the stages that use the address of the client, like the access log and the rate limiter.
+func WithTrustedProxies(next http.Handler) http.Handler {
+	trusted, err := ParseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		panic(err)
+	}
+	trustUnix := slices.Contains(config.TrustedProxies, trustUnixPeers)
+	if len(trusted) == 0 && !trustUnix {
+		return next
+	}
+	isTrusted := func(a netip.Addr) bool {
+		a = a.Unmap()
+		for _, p := range trusted {
+			if p.Contains(a) {
+				return true
+			}
+		}
+		return false
+	}
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		peer, _ := parseNode(r.RemoteAddr)
+		if !(peer.IsValid() && isTrusted(peer) || trustUnix && isUnixPeer(r)) {
+			for _, h := range forwardingHeaders {
+				r.Header.Del(h)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var hops []hop
+		if v := r.Header.Values("Forwarded"); len(v) > 0 {
+			hops = parseForwarded(strings.Join(v, ","))
+		} else {
+			hops = parseXForwarded(r.Header)
+		}
+		if len(hops) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// The client is the first address, counting from the end, that is not one of our proxies
+		c := 0
+		for i := len(hops) - 1; i >= 0; i-- {
+			if !hops[i].addr.IsValid() || !isTrusted(hops[i].addr) {
+				c = i
+				break
+			}
+		}
+		client := hops[c]
+		if client.prefix == "" {
+			// The Forwarded header has no prefix parameter, so take it from the proxy closest to us
+			if l := splitList(r.Header.Values("X-Forwarded-Prefix")); len(l) > 0 {
+				client.prefix = l[len(l)-1]
+			}
+		}
+
+		r2 := r.WithContext(r.Context())
+		u := *r.URL
+		r2.URL = &u
+		if client.addr.IsValid() {
+			port := client.port
+			if port == "" {
+				port = "0"
+			}
+			r2.RemoteAddr = net.JoinHostPort(client.addr.Unmap().String(), port)
+		}
+		if client.proto == "http" || client.proto == "https" {
+			r2.URL.Scheme = client.proto
+		}
+		if client.host != "" && validHost(client.host) {
+			r2.Host = client.host
+			r2.URL.Host = client.host
+		}
+		if p, ok := cleanPrefix(client.prefix); ok {
+			r2 = r2.WithContext(context.WithValue(r2.Context(), forwardedPrefixContext{}, p))
+		}
+		next.ServeHTTP(w, r2)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// isUnixPeer returns true if r came in on a unix socket listener.
+func isUnixPeer(r *http.Request) bool {
+	a, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && (a.Network() == "unix" || a.Network() == "unixpacket")
+}
+
+// ForwardedPrefix returns the path prefix that a trusted proxy reported in the X-Forwarded-Prefix header,
+// or an empty string if there was none.
+//
+// A proxy that sends X-Forwarded-Prefix removes the prefix from the path before passing on the request, so
+// handlers must add it back to the paths they send to the browser. The server does not do this for you, so
+// make those paths with MakeRequestLocalPath.
+func ForwardedPrefix(ctx context.Context) string {
+	p, _ := ctx.Value(forwardedPrefixContext{}).(string)
+	return p
+}
+
+// MakeRequestLocalPath is like MakeLocalPath, but if config.ProxyPath is blank, it uses the path prefix reported
+// by a trusted proxy in the X-Forwarded-Prefix header of the request instead. This lets the same application
+// be served from different paths by proxies that strip the prefix.
+//
+// Functions that do not have the request, like Redirect and GetAssetUrl, use MakeLocalPath, so use this in
+// their place for paths that must work under a forwarded prefix.
+func MakeRequestLocalPath(ctx context.Context, p string) string {
+	prefix := ForwardedPrefix(ctx)
+	if config.ProxyPath != "" || prefix == "" || p == "" || p[0] != '/' {
+		return MakeLocalPath(p)
+	}
+	hasSlash := p[len(p)-1] == '/'
+	p = path.Join(prefix, p)
+	if hasSlash && p[len(p)-1] != '/' {
+		p += "/"
+	}
+	return p
+}
+
+// parseNode parses a node of a Forwarded header, or an address from X-Forwarded-For or RemoteAddr.
+// The address is not valid if the node is "unknown" or an obfuscated identifier.
+func parseNode(s string) (netip.Addr, string) {
+	s = strings.TrimSpace(s)
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr(), strconv.Itoa(int(ap.Port()))
+	}
+	if a, err := netip.ParseAddr(strings.Trim(s, "[]")); err == nil {
+		return a, ""
+	}
+	return netip.Addr{}, ""
+}
+
+// parseForwarded parses the elements of an RFC 7239 Forwarded header, like
+//
+//	for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8:cafe::17]:4711"
+func parseForwarded(v string) []hop {
+	var hops []hop
+	var h hop
+	var hasFor bool
+	for len(v) > 0 {
+		var pair string
+		var sep byte
+		pair, sep, v = nextPair(v)
+		key, value, ok := strings.Cut(pair, "=")
+		if ok {
+			value = unquote(strings.TrimSpace(value))
+			switch strings.ToLower(strings.TrimSpace(key)) {
+			case "for":
+				h.addr, h.port = parseNode(value)
+				hasFor = true
+			case "proto":
+				h.proto = strings.ToLower(value)
+			case "host":
+				h.host = value
+			}
+		}
+		if sep != ';' {
+			if hasFor {
+				hops = append(hops, h)
+			}
+			h = hop{}
+			hasFor = false
+		}
+	}
+	if hasFor { // the header ended with a ';'
+		hops = append(hops, h)
+	}
+	return hops
+}
+
+// nextPair returns the text before the next ';' or ',' that is not in a quoted string, and the separator found.
+func nextPair(v string) (pair string, sep byte, rest string) {
+	quoted := false
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case (c == ';' || c == ',') && !quoted:
+			return v[:i], c, v[i+1:]
+		}
+	}
+	return v, 0, ""
+}
+
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	s = s[1 : len(s)-1]
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// parseXForwarded reads the X-Forwarded-* headers. Each proxy adds to the end of the lists, so the values of
+// the other headers are matched to the addresses counting from the end, since a client can put anything
+// at the start.
+func parseXForwarded(h http.Header) []hop {
+	fors := splitList(h.Values("X-Forwarded-For"))
+	if len(fors) == 0 {
+		return nil
+	}
+	protos := splitList(h.Values("X-Forwarded-Proto"))
+	hosts := splitList(h.Values("X-Forwarded-Host"))
+	prefixes := splitList(h.Values("X-Forwarded-Prefix"))
+	at := func(list []string, i int) string {
+		// Proxies often send one value for the whole chain. Use it for every address.
+		if len(list) == 0 {
+			return ""
+		}
+		j := len(list) - (len(fors) - i)
+		return list[max(j, 0)]
+	}
+	hops := make([]hop, len(fors))
+	for i, f := range fors {
+		hops[i].addr, hops[i].port = parseNode(f)
+		hops[i].proto = strings.ToLower(at(protos, i))
+		hops[i].host = at(hosts, i)
+		hops[i].prefix = at(prefixes, i)
+	}
+	return hops
+}
+
+func splitList(values []string) []string {
+	var list []string
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
+
+// validHost returns true if h looks like a host name or address with an optional port.
+func validHost(h string) bool {
+	if len(h) > 255 {
+		return false
+	}
+	for i := 0; i < len(h); i++ {
+		c := h[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '.' || c == '-' || c == ':' || c == '[' || c == ']') {
+			return false
+		}
+	}
+	return true
+}
+
+// cleanPrefix returns the path prefix p without a trailing slash, if it is a safe path to put in front of other paths.
+func cleanPrefix(p string) (string, bool) {
+	if p == "" || p[0] != '/' || strings.HasPrefix(p, "//") || strings.ContainsAny(p, "\\?#\x00") {
+		return "", false
+	}
+	p = path.Clean(p)
+	if p == "/" || strings.Contains(p, "..") {
+		return "", false
+	}
+	return p, true
+}
@@ -0,0 +1,122 @@
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goradd/serve/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithTrustedProxies(t *testing.T) {
+	clearGlobals()
+	config.TrustedProxies = []string{"10.0.0.0/8", "2001:db8::1"}
+	defer func() { config.TrustedProxies = nil }()
+
+	var got *http.Request
+	h := WithTrustedProxies(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		addr    string
+		scheme  string
+		host    string
+		prefix  string
+	}{
+		{"untrusted peer", "192.0.2.1:1234",
+			map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Forwarded-Proto": "https"},
+			"192.0.2.1:1234", "", "example.com", ""},
+		{"x-forwarded", "10.0.0.1:1234",
+			map[string]string{"X-Forwarded-For": "192.0.2.7", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "shop.example.com", "X-Forwarded-Prefix": "/shop/"},
+			"192.0.2.7:0", "https", "shop.example.com", "/shop"},
+		{"spoofed x-forwarded-for", "10.0.0.1:1234",
+			map[string]string{"X-Forwarded-For": "6.6.6.6, 192.0.2.7, 10.0.0.2"},
+			"192.0.2.7:0", "", "example.com", ""},
+		{"all trusted", "10.0.0.1:1234",
+			map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"},
+			"10.0.0.3:0", "", "example.com", ""},
+		{"forwarded", "[2001:db8::1]:443",
+			map[string]string{"Forwarded": `for="[2001:db8:cafe::17]:4711";proto=https;host="a.example.com", for=10.1.1.1;proto=http`},
+			"[2001:db8:cafe::17]:4711", "https", "a.example.com", ""},
+		{"forwarded hidden client", "10.0.0.1:1234",
+			map[string]string{"Forwarded": `for=_hidden;proto=https`},
+			"10.0.0.1:1234", "https", "example.com", ""},
+		{"bad host and prefix", "10.0.0.1:1234",
+			map[string]string{"X-Forwarded-For": "192.0.2.7", "X-Forwarded-Host": "evil.com/x", "X-Forwarded-Prefix": "//evil.com"},
+			"192.0.2.7:0", "", "example.com", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/page", nil)
+			r.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			assert.Equal(t, tt.addr, got.RemoteAddr)
+			assert.Equal(t, tt.scheme, got.URL.Scheme)
+			assert.Equal(t, tt.host, got.Host)
+			assert.Equal(t, tt.prefix, ForwardedPrefix(got.Context()))
+			if tt.name == "untrusted peer" {
+				assert.Empty(t, got.Header.Get("X-Forwarded-For"), "headers from untrusted peers are removed")
+			}
+		})
+	}
+}
+
+func TestMakeRequestLocalPath(t *testing.T) {
+	clearGlobals()
+	config.TrustedProxies = []string{"10.0.0.1"}
+	defer func() { config.TrustedProxies = nil }()
+
+	var paths []string
+	h := WithTrustedProxies(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = []string{MakeRequestLocalPath(r.Context(), "/a/b/"), MakeRequestLocalPath(r.Context(), "rel")}
+	}))
+	r := httptest.NewRequest("GET", "/a/b/", nil)
+	r.RemoteAddr = "10.0.0.1:80"
+	r.Header.Set("X-Forwarded-For", "192.0.2.7")
+	r.Header.Set("X-Forwarded-Prefix", "/app")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, []string{"/app/a/b/", "rel"}, paths)
+
+	config.ProxyPath = "/static"
+	defer clearGlobals()
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, "/static/a/b/", paths[0], "a static ProxyPath wins")
+}
+
+func TestWithTrustedProxies_Unix(t *testing.T) {
+	clearGlobals()
+	defer func() { config.TrustedProxies = nil }()
+
+	var got *http.Request
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	})
+	request := func() *http.Request {
+		r := httptest.NewRequest("GET", "/page", nil)
+		r.RemoteAddr = "@"
+		r.Header.Set("X-Forwarded-For", "192.0.2.7")
+		r.Header.Set("X-Forwarded-Proto", "https")
+		ctx := context.WithValue(r.Context(), http.LocalAddrContextKey, &net.UnixAddr{Name: "/tmp/app.sock", Net: "unix"})
+		return r.WithContext(ctx)
+	}
+
+	config.TrustedProxies = []string{"10.0.0.1"}
+	WithTrustedProxies(handler).ServeHTTP(httptest.NewRecorder(), request())
+	assert.Equal(t, "@", got.RemoteAddr)
+	assert.Empty(t, got.Header.Get("X-Forwarded-For"))
+
+	config.TrustedProxies = []string{"unix"}
+	WithTrustedProxies(handler).ServeHTTP(httptest.NewRecorder(), request())
+	assert.Equal(t, "192.0.2.7:0", got.RemoteAddr)
+	assert.Equal(t, "https", got.URL.Scheme)
+}
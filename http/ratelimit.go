@@ -0,0 +1,269 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/goradd/serve/log"
+	"github.com/goradd/serve/session"
+)
+
+// RateKeyFunc returns the key that a request is counted under by a rate limit.
+// Requests with the same key share a bucket of tokens. Return an empty string to not limit the request.
+type RateKeyFunc func(r *http.Request) string
+
+// RateKeyIP counts requests by the IP address of the client.
+//
+// If the server is behind a proxy, put the trusted proxy middleware in front of the rate limiter,
+// so that the address is the one of the client and not of the proxy.
+func RateKeyIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// RateKeySession counts requests by session, or by IP address if the request has no session yet.
+// The rate limiter must come after the session handler for this to work.
+//
+// The session is identified by session.LogID, so that the token, which must be kept secret, is not
+// written to a shared RateStore.
+func RateKeySession(r *http.Request) string {
+	if id := session.LogID(r.Context()); id != "" {
+		return "session:" + id
+	}
+	return RateKeyIP(r)
+}
+
+// maxRateKeyHeaderLength is the number of bytes of a header value that RateKeyHeader uses.
+const maxRateKeyHeaderLength = 256
+
+// RateKeyHeader returns a RateKeyFunc that counts requests by the value of the given header, like an API key.
+// Requests without the header are counted by IP address. The value is hashed, so that secrets like API keys are
+// not written to a shared RateStore.
+//
+// A client can get a new bucket with each request just by changing the value, so only use it for headers that
+// an earlier stage has checked, like an API key that has been authenticated.
+func RateKeyHeader(name string) RateKeyFunc {
+	return func(r *http.Request) string {
+		v := r.Header.Get(name)
+		if v == "" {
+			return RateKeyIP(r)
+		}
+		if len(v) > maxRateKeyHeaderLength {
+			v = v[:maxRateKeyHeaderLength]
+		}
+		sum := sha256.Sum256([]byte(v))
+		return "header:" + hex.EncodeToString(sum[:16])
+	}
+}
+
+// RateLimit is a token bucket rate limiting policy for a route.
+//
+// Each key gets a bucket that holds up to Burst tokens, and is refilled at Limit tokens per Period.
+// Each request takes a token, and requests that find the bucket empty are answered with 429 Too Many Requests.
+type RateLimit struct {
+	// Name identifies the buckets of the policy in the store. Routes with policies of the same name share buckets,
+	// so that, for example, the login and password reset pages can share one limit. It defaults to the pattern of the route.
+	Name string
+	// Limit is the number of requests allowed per Period.
+	Limit int
+	// Period is the length of time over which Limit requests are allowed.
+	Period time.Duration
+	// Burst is the number of requests that can be made at once. It defaults to Limit.
+	Burst int
+	// Key returns the key that a request is counted under. It defaults to RateKeyIP.
+	Key RateKeyFunc
+}
+
+// RateResult is the result of taking a token from a bucket.
+type RateResult struct {
+	// Allowed is true if there was a token in the bucket.
+	Allowed bool
+	// Remaining is the number of whole tokens left in the bucket.
+	Remaining int
+	// RetryAfter is the amount of time until the next token will be available, if none was.
+	RetryAfter time.Duration
+	// Reset is the amount of time until the bucket will be full again.
+	Reset time.Duration
+}
+
+// RateStore holds the token buckets of the rate limiter.
+//
+// The default is an in-memory store. Implement this interface on top of a shared store, like Redis,
+// to apply the limits across multiple copies of the application. Take must check and update the bucket in
+// one atomic operation.
+type RateStore interface {
+	// Take refills the bucket for key at rate tokens per second up to burst tokens, and then takes one token from it.
+	Take(ctx context.Context, key string, burst int, rate float64) (RateResult, error)
+}
+
+// DefaultRateStore is the store used by WithRateLimits. Replace it from an init() function to use a shared store.
+var DefaultRateStore RateStore = NewMemoryRateStore()
+
+var rateLimitsMu sync.RWMutex
+var rateLimits = make(map[string]RateLimit)
+
+// RegisterRateLimit sets the rate limit of the route registered with pattern. pattern must be the
+// same as the pattern given to RegisterAppHandler or RegisterDrawFunc.
+//
+// You may call this from an init() function.
+func RegisterRateLimit(pattern string, l RateLimit) {
+	if l.Limit <= 0 || l.Period <= 0 {
+		panic("a rate limit needs a Limit and a Period greater than zero")
+	}
+	if l.Burst <= 0 {
+		l.Burst = l.Limit
+	}
+	if l.Key == nil {
+		l.Key = RateKeyIP
+	}
+	pattern = joinProxyPath(pattern)
+	if l.Name == "" {
+		l.Name = pattern
+	}
+	rateLimitsMu.Lock()
+	defer rateLimitsMu.Unlock()
+	rateLimits[pattern] = l
+}
+
+func rateLimitFor(r *http.Request) (RateLimit, bool) {
+	rateLimitsMu.RLock()
+	defer rateLimitsMu.RUnlock()
+	if len(rateLimits) == 0 {
+		return RateLimit{}, false
+	}
+	_, pattern := AppMuxer.Handler(r)
+	l, ok := rateLimits[pattern]
+	return l, ok
+}
+
+// WithRateLimits is middleware that applies the rate limits registered with RegisterRateLimit to the routes
+// of the AppMuxer, using the buckets in DefaultRateStore.
+//
+// Each limited response gets the RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy headers
+// described by the IETF RateLimit header fields draft. Requests over the limit are answered with
+// 429 Too Many Requests and a Retry-After header. If the store fails, the error is logged and the request is let through.
+//
+// It must come after the session handler if any of the limits use RateKeySession.
+func WithRateLimits(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		l, ok := rateLimitFor(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := l.Key(r)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		res, err := DefaultRateStore.Take(ctx, l.Name+"|"+key, l.Burst, float64(l.Limit)/l.Period.Seconds())
+		if err != nil {
+			log.Error(ctx, logModule, "Rate limit store failed", slog.Any("error", err))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(l.Burst))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(seconds(res.Reset)))
+		h.Set("RateLimit-Policy", strconv.Itoa(l.Limit)+";w="+strconv.Itoa(seconds(l.Period)))
+		if !res.Allowed {
+			log.Debug(ctx, logModule, "Rate limit exceeded",
+				slog.String("policy", l.Name),
+				slog.String("path", r.URL.Path))
+			h.Set("Retry-After", strconv.Itoa(max(seconds(res.RetryAfter), 1)))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// seconds rounds d up to a whole number of seconds.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// MemoryRateStore is a RateStore that keeps the token buckets in memory.
+type MemoryRateStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// full is when the bucket will be full again, after which it can be forgotten.
+	full time.Time
+}
+
+// NewMemoryRateStore returns a new MemoryRateStore.
+func NewMemoryRateStore() *MemoryRateStore {
+	return &MemoryRateStore{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Take refills the bucket for key and takes one token from it.
+func (s *MemoryRateStore) Take(_ context.Context, key string, burst int, rate float64) (RateResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	var res RateResult
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = time.Duration((float64(burst) - b.tokens) / rate * float64(time.Second))
+	b.full = now.Add(res.Reset)
+	return res, nil
+}
+
+// sweep forgets the buckets that have filled up again, once a minute, so that the store does not keep growing.
+func (s *MemoryRateStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for k, b := range s.buckets {
+		if !now.Before(b.full) {
+			delete(s.buckets, k)
+		}
+	}
+}
+
+// Len returns the number of buckets in the store.
+func (s *MemoryRateStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buckets)
+}
@@ -0,0 +1,119 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goradd/serve/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRateStore(t *testing.T) {
+	s := NewMemoryRateStore()
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// A bucket of 2 that refills at 1 per second
+	for i, want := range []RateResult{
+		{Allowed: true, Remaining: 1, Reset: time.Second},
+		{Allowed: true, Remaining: 0, Reset: 2 * time.Second},
+		{Allowed: false, Remaining: 0, RetryAfter: time.Second, Reset: 2 * time.Second},
+	} {
+		res, err := s.Take(ctx, "a", 2, 1)
+		require.NoError(t, err)
+		assert.Equal(t, want, res, "take %d", i)
+	}
+
+	now = now.Add(1500 * time.Millisecond)
+	res, _ := s.Take(ctx, "a", 2, 1)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+
+	res, _ = s.Take(ctx, "b", 2, 1)
+	assert.True(t, res.Allowed, "keys have their own buckets")
+	assert.Equal(t, 2, s.Len())
+
+	now = now.Add(time.Hour)
+	_, _ = s.Take(ctx, "c", 2, 1)
+	assert.Equal(t, 1, s.Len(), "full buckets are forgotten")
+}
+
+func TestWithRateLimits(t *testing.T) {
+	clearGlobals()
+	AppMuxer = http.NewServeMux()
+	rateLimits = make(map[string]RateLimit)
+	DefaultRateStore = NewMemoryRateStore()
+	defer func() { rateLimits = make(map[string]RateLimit) }()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	RegisterAppHandler("/login", ok)
+	RegisterAppHandler("/api/", ok)
+	RegisterAppHandler("/free", ok)
+	RegisterRateLimit("/login", RateLimit{Limit: 2, Period: time.Minute})
+	RegisterRateLimit("/api/", RateLimit{Limit: 1, Period: time.Second, Key: RateKeySession})
+
+	h := WithRateLimits(WithAppMuxer(http.NotFoundHandler()))
+	get := func(path, ip, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = ip + ":1234"
+		if token != "" {
+			r = r.WithContext(session.WithToken(r.Context(), token))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/login", "10.0.0.1", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", w.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "2;w=60", w.Header().Get("RateLimit-Policy"))
+
+	assert.Equal(t, http.StatusOK, get("/login", "10.0.0.1", "").Code)
+	w = get("/login", "10.0.0.1", "")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, get("/login", "10.0.0.2", "").Code, "another IP has its own bucket")
+
+	assert.Equal(t, http.StatusOK, get("/api/x", "10.0.0.1", "abc").Code)
+	assert.Equal(t, http.StatusTooManyRequests, get("/api/y", "10.0.0.2", "abc").Code, "the session follows the user")
+	assert.Equal(t, http.StatusOK, get("/api/x", "10.0.0.1", "def").Code)
+
+	w = get("/free", "10.0.0.1", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+}
+
+func TestRateKeys(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	assert.Equal(t, "10.0.0.1", RateKeySession(r))
+	assert.Equal(t, "10.0.0.1", RateKeyHeader("X-Api-Key")(r))
+
+	s := r.WithContext(session.WithToken(r.Context(), "secret-token"))
+	k := RateKeySession(s)
+	assert.NotContains(t, k, "secret-token")
+	assert.Equal(t, "session:"+session.LogID(s.Context()), k)
+
+	key := RateKeyHeader("X-Api-Key")
+	r.Header.Set("X-Api-Key", "secret-key")
+	k = key(r)
+	assert.NotContains(t, k, "secret-key")
+	assert.Equal(t, k, key(r))
+	r.Header.Set("X-Api-Key", "other-key")
+	assert.NotEqual(t, k, key(r))
+
+	long := strings.Repeat("a", maxRateKeyHeaderLength)
+	r.Header.Set("X-Api-Key", long+"b")
+	k = key(r)
+	r.Header.Set("X-Api-Key", long+"c")
+	assert.Equal(t, k, key(r), "only the start of long values is used")
+}
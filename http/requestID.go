@@ -0,0 +1,57 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/goradd/serve/log"
+)
+
+// RequestIDHeader is the header that carries the ID of a request, both in the request and in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits the size of the IDs accepted from clients, since they end up in every log message.
+const maxRequestIDLength = 128
+
+// WithRequestID is middleware that gives each request an ID, so that the log messages made while serving
+// the request can be tied together.
+//
+// If the request has an X-Request-ID header, like one set by a load balancer, that ID is used, as long
+// as it is made of up to 128 printable ASCII characters. Otherwise, a random ID is made. The ID is put in the
+// context of the request with log.WithRequestID, so that the log functions add it to each message,
+// and is sent back in the X-Request-ID header of the response. Get it with log.RequestID.
+//
+// It must come before the stages that log, like the access log and the error handler, so that they can log the ID.
+// In the pipeline of ServerBase it comes right after the trusted proxies and tracing stages, which do not log
+// for each request.
+func WithRequestID(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(log.WithRequestID(r.Context(), id)))
+	}
+	return http.HandlerFunc(fn)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random 128-bit ID in hex.
+func newRequestID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
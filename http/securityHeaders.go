@@ -0,0 +1,127 @@
+package http
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SecurityHeaders is a set of response headers that tell the browser to turn on protections against
+// cross-site scripting, clickjacking, content sniffing and cross-origin data leaks.
+// Headers whose fields are blank are not sent.
+type SecurityHeaders struct {
+	// ContentSecurityPolicy is the Content-Security-Policy header, which limits where the page may load
+	// scripts, styles, frames and other resources from.
+	ContentSecurityPolicy string
+	// CSPReportOnly sends the ContentSecurityPolicy in the Content-Security-Policy-Report-Only header instead,
+	// so that the browser reports violations of the policy without blocking anything. Use it to try out a
+	// new policy. Add a report-to or report-uri directive to the policy to receive the reports.
+	CSPReportOnly bool
+	// ContentTypeOptions is the X-Content-Type-Options header. "nosniff" stops the browser from
+	// guessing the type of a response that does not match its Content-Type.
+	ContentTypeOptions string
+	// FrameOptions is the X-Frame-Options header, "DENY" or "SAMEORIGIN", which controls which pages may
+	// put this page in a frame. The frame-ancestors directive of the ContentSecurityPolicy replaces it in modern
+	// browsers, so keep the two in agreement.
+	FrameOptions string
+	// ReferrerPolicy is the Referrer-Policy header, which limits the information sent in the Referer header
+	// when following links away from the page.
+	ReferrerPolicy string
+	// PermissionsPolicy is the Permissions-Policy header, which turns off browser features, like
+	// "camera=(), microphone=(), geolocation=()".
+	PermissionsPolicy string
+	// CrossOriginOpenerPolicy is the Cross-Origin-Opener-Policy header. "same-origin" keeps windows of other
+	// sites that open this page, or are opened by it, from getting a reference to it.
+	CrossOriginOpenerPolicy string
+	// CrossOriginEmbedderPolicy is the Cross-Origin-Embedder-Policy header. "require-corp" only lets the page load
+	// cross-origin resources that allow it, which some browser features require.
+	CrossOriginEmbedderPolicy string
+	// CrossOriginResourcePolicy is the Cross-Origin-Resource-Policy header, which controls which sites may
+	// load this response as a resource, like an image or script.
+	CrossOriginResourcePolicy string
+}
+
+// DefaultSecurityHeaders are the security headers sent by ServerBase unless they are changed.
+//
+// The default policy does not limit where scripts and styles come from, since that depends on the application.
+// Tighten it once you know what the application loads, trying the new policy with CSPReportOnly first.
+var DefaultSecurityHeaders = SecurityHeaders{
+	ContentSecurityPolicy:     "frame-ancestors 'self'; object-src 'none'; base-uri 'self'",
+	ContentTypeOptions:        "nosniff",
+	FrameOptions:              "SAMEORIGIN",
+	ReferrerPolicy:            "strict-origin-when-cross-origin",
+	CrossOriginOpenerPolicy:   "same-origin",
+	CrossOriginResourcePolicy: "same-site",
+}
+
+// Write sets the headers on w.
+func (h *SecurityHeaders) Write(w http.ResponseWriter) {
+	hdr := w.Header()
+	set := func(name, value string) {
+		if value != "" {
+			hdr.Set(name, value)
+		}
+	}
+	if h.CSPReportOnly {
+		set("Content-Security-Policy-Report-Only", h.ContentSecurityPolicy)
+	} else {
+		set("Content-Security-Policy", h.ContentSecurityPolicy)
+	}
+	set("X-Content-Type-Options", h.ContentTypeOptions)
+	set("X-Frame-Options", h.FrameOptions)
+	set("Referrer-Policy", h.ReferrerPolicy)
+	set("Permissions-Policy", h.PermissionsPolicy)
+	set("Cross-Origin-Opener-Policy", h.CrossOriginOpenerPolicy)
+	set("Cross-Origin-Embedder-Policy", h.CrossOriginEmbedderPolicy)
+	set("Cross-Origin-Resource-Policy", h.CrossOriginResourcePolicy)
+}
+
+type prefixHeaders struct {
+	prefix  string
+	headers SecurityHeaders
+}
+
+var securityHeadersMu sync.RWMutex
+var securityHeaderPrefixes []prefixHeaders // longest prefix first
+
+// RegisterSecurityHeaders sets the security headers of the requests whose path starts with prefix, in place of
+// the headers that would otherwise be sent. If more than one prefix matches, the longest one wins.
+//
+// Start from DefaultSecurityHeaders and change what is different, like letting the pages of an embeddable widget
+// be framed by other sites:
+//
+//	h := http.DefaultSecurityHeaders
+//	h.FrameOptions = ""
+//	h.ContentSecurityPolicy = "frame-ancestors *"
+//	http.RegisterSecurityHeaders("/widget/", h)
+//
+// You may call this from an init() function.
+func RegisterSecurityHeaders(prefix string, h SecurityHeaders) {
+	prefix = joinProxyPath(prefix)
+	securityHeadersMu.Lock()
+	defer securityHeadersMu.Unlock()
+	for i := range securityHeaderPrefixes {
+		if securityHeaderPrefixes[i].prefix == prefix {
+			securityHeaderPrefixes[i].headers = h
+			return
+		}
+	}
+	securityHeaderPrefixes = append(securityHeaderPrefixes, prefixHeaders{prefix, h})
+	sort.SliceStable(securityHeaderPrefixes, func(i, j int) bool {
+		return len(securityHeaderPrefixes[i].prefix) > len(securityHeaderPrefixes[j].prefix)
+	})
+}
+
+// SecurityHeadersFor returns the security headers registered for the longest prefix of path,
+// and false if none was registered.
+func SecurityHeadersFor(path string) (SecurityHeaders, bool) {
+	securityHeadersMu.RLock()
+	defer securityHeadersMu.RUnlock()
+	for _, p := range securityHeaderPrefixes {
+		if strings.HasPrefix(path, p.prefix) {
+			return p.headers, true
+		}
+	}
+	return SecurityHeaders{}, false
+}
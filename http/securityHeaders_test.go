@@ -0,0 +1,49 @@
+package http
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecurityHeaders_Write(t *testing.T) {
+	w := httptest.NewRecorder()
+	h := DefaultSecurityHeaders
+	h.PermissionsPolicy = "camera=()"
+	h.Write(w)
+	assert.Equal(t, "frame-ancestors 'self'; object-src 'none'; base-uri 'self'", w.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "SAMEORIGIN", w.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "strict-origin-when-cross-origin", w.Header().Get("Referrer-Policy"))
+	assert.Equal(t, "camera=()", w.Header().Get("Permissions-Policy"))
+	assert.Equal(t, "same-origin", w.Header().Get("Cross-Origin-Opener-Policy"))
+	assert.Equal(t, "same-site", w.Header().Get("Cross-Origin-Resource-Policy"))
+	assert.NotContains(t, w.Header(), "Cross-Origin-Embedder-Policy", "blank headers are not sent")
+
+	w = httptest.NewRecorder()
+	h.CSPReportOnly = true
+	h.Write(w)
+	assert.Empty(t, w.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, h.ContentSecurityPolicy, w.Header().Get("Content-Security-Policy-Report-Only"))
+}
+
+func TestRegisterSecurityHeaders(t *testing.T) {
+	clearGlobals()
+	defer func() { securityHeaderPrefixes = nil }()
+
+	widget := DefaultSecurityHeaders
+	widget.FrameOptions = ""
+	RegisterSecurityHeaders("/widget/", widget)
+	api := SecurityHeaders{ContentTypeOptions: "nosniff"}
+	RegisterSecurityHeaders("/widget/api/", api)
+
+	h, ok := SecurityHeadersFor("/widget/api/x")
+	assert.True(t, ok)
+	assert.Equal(t, api, h, "the longest prefix wins")
+	h, ok = SecurityHeadersFor("/widget/page")
+	assert.True(t, ok)
+	assert.Equal(t, widget, h)
+	_, ok = SecurityHeadersFor("/other")
+	assert.False(t, ok)
+}
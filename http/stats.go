@@ -0,0 +1,71 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/goradd/serve/metrics"
+)
+
+var requestCount = metrics.NewCounter("goradd_http_requests_total",
+	"Requests served, by route pattern, method and status class.", "pattern", "method", "status")
+var requestDuration = metrics.NewHistogram("goradd_http_request_duration_seconds",
+	"Time taken to serve requests, by route pattern and status class.", nil, "pattern", "status")
+var requestsInFlight = metrics.NewGauge("goradd_http_requests_in_flight",
+	"Requests being served.")
+
+// WithStats is middleware that records the number of requests served, the time taken to serve them, and the number
+// of requests being served in the metrics package.
+//
+// Requests are counted by the pattern of the route that serves them, as returned by the Handler function of the
+// PatternMuxer or AppMuxer, rather than by path, so that the number of series stays small. Requests that
+// no pattern matches are counted under "other".
+//
+// It should come near the start of the handler stack, so that it sees the errors made by the stages after it.
+func WithStats(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		pattern := routePattern(r)
+		if pattern == "" {
+			pattern = "other"
+		}
+		method := statsMethod(r.Method)
+		requestsInFlight.Inc()
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			requestsInFlight.Dec()
+			status := sw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			class := strconv.Itoa(status/100) + "xx"
+			requestCount.Inc(pattern, method, class)
+			requestDuration.Observe(time.Since(start).Seconds(), pattern, class)
+		}()
+		next.ServeHTTP(sw, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// routePattern returns the pattern of the route that will serve r, or an empty string if no route matches.
+func routePattern(r *http.Request) string {
+	if _, p := PatternMuxer.Handler(r); p != "" {
+		return p
+	}
+	if _, p := AppMuxer.Handler(r); p != "" {
+		return p
+	}
+	return ""
+}
+
+// statsMethod returns the method of a request, folding the methods that are not standard into one,
+// since clients can send anything there.
+func statsMethod(m string) string {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return m
+	}
+	return "OTHER"
+}
@@ -0,0 +1,49 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithStats(t *testing.T) {
+	clearGlobals()
+	AppMuxer = http.NewServeMux()
+	PatternMuxer = http.NewServeMux()
+
+	RegisterAppHandler("/item/{id}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/item/0" {
+			http.NotFound(w, r)
+		}
+	}))
+	RegisterStaticHandler("/healthz", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h := WithStats(WithPatternMuxer(WithAppMuxer(http.NotFoundHandler())))
+
+	before := func(pattern, method, status string) float64 {
+		return requestCount.Value(pattern, method, status)
+	}
+	item2xx := before("/item/{id}", "GET", "2xx")
+	item4xx := before("/item/{id}", "GET", "4xx")
+	health := before("/healthz", "GET", "2xx")
+	other := before("other", "OTHER", "4xx")
+	count := requestDuration.Count("/item/{id}", "2xx")
+
+	for _, tt := range []struct{ method, path string }{
+		{"GET", "/item/1"},
+		{"GET", "/item/2"},
+		{"GET", "/item/0"},
+		{"GET", "/healthz"},
+		{"BREW", "/coffee"},
+	} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+	}
+
+	assert.Equal(t, item2xx+2, before("/item/{id}", "GET", "2xx"), "requests are counted by pattern, not path")
+	assert.Equal(t, item4xx+1, before("/item/{id}", "GET", "4xx"))
+	assert.Equal(t, health+1, before("/healthz", "GET", "2xx"))
+	assert.Equal(t, other+1, before("other", "OTHER", "4xx"))
+	assert.Equal(t, count+2, requestDuration.Count("/item/{id}", "2xx"))
+	assert.Equal(t, 0.0, requestsInFlight.Value())
+}
@@ -0,0 +1,73 @@
+package http
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/goradd/serve/trace"
+)
+
+// WithTracing is middleware that starts the server span of each request, continuing the trace of the caller
+// if the request has a traceparent header. The spans of the later stages of the handler stack, and of anything
+// else started with the context of the request, are children of this span.
+//
+// The span is named after the method and route pattern of the request, like "GET /item/{id}". It is marked as failed
+// if the response is a server error. If no tracer has been set with trace.SetTracer when the handler stack is made,
+// it does nothing.
+func WithTracing(next http.Handler) http.Handler {
+	if !trace.Enabled() {
+		return next
+	}
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, ok := trace.Extract(r.Header); ok {
+			ctx = trace.ContextWithRemoteParent(ctx, sc)
+		}
+		name := r.Method
+		pattern := routePattern(r)
+		if pattern != "" {
+			name += " " + pattern
+		}
+		ctx, span := trace.Start(ctx, name, trace.SpanKindServer)
+		defer span.End()
+		if !span.IsRecording() {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		span.SetAttributes(
+			slog.String("http.request.method", r.Method),
+			slog.String("url.path", r.URL.Path),
+			slog.String("http.route", pattern),
+			slog.String("user_agent.original", r.UserAgent()))
+
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			status := sw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			span.SetAttributes(slog.Int("http.response.status_code", status))
+			if status >= 500 {
+				span.SetError(fmt.Errorf("%d %s", status, http.StatusText(status)))
+			}
+		}()
+		next.ServeHTTP(sw, r.WithContext(ctx))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// traceStage wraps the handler made by a stage of a Pipeline with a span named after the stage, so that
+// a trace shows the time spent in each stage. Spans are only made for requests that are being traced by WithTracing.
+func traceStage(name string, h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !trace.SpanFromContext(r.Context()).IsRecording() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ctx, span := trace.Start(r.Context(), "stage "+name, trace.SpanKindInternal)
+		defer span.End()
+		h.ServeHTTP(w, r.WithContext(ctx))
+	}
+	return http.HandlerFunc(fn)
+}
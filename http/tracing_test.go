@@ -0,0 +1,64 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/goradd/serve/trace"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memExporter struct {
+	mu    sync.Mutex
+	spans []trace.SpanData
+}
+
+func (e *memExporter) Export(_ context.Context, spans []trace.SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestWithTracing(t *testing.T) {
+	clearGlobals()
+	AppMuxer = http.NewServeMux()
+	PatternMuxer = http.NewServeMux()
+	RegisterAppHandler("/item/{id}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	exp := new(memExporter)
+	tr := trace.NewBatchTracer(exp, 1)
+	trace.SetTracer(tr)
+	defer trace.SetTracer(nil)
+
+	p := NewPipeline(
+		Stage{"tracing", WithTracing},
+		Stage{"bufferedOutput", WithBufferedOutput},
+		Stage{"appMuxer", WithAppMuxer},
+	)
+	h, err := p.Build(http.NotFoundHandler())
+	require.NoError(t, err)
+
+	r := httptest.NewRequest("GET", "/item/5", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	require.NoError(t, tr.Shutdown(context.Background()))
+
+	// Spans end from the innermost out
+	require.Len(t, exp.spans, 3)
+	muxer, buffered, server := exp.spans[0], exp.spans[1], exp.spans[2]
+	assert.Equal(t, "stage appMuxer", muxer.Name)
+	assert.Equal(t, "stage bufferedOutput", buffered.Name)
+	assert.Equal(t, "GET /item/{id}", server.Name)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent.String())
+	assert.Equal(t, server.SpanContext.SpanID, buffered.Parent)
+	assert.Equal(t, buffered.SpanContext.SpanID, muxer.Parent)
+	assert.Equal(t, trace.StatusError, server.Status)
+}
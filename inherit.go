@@ -0,0 +1,336 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/goradd/serve/config"
+	"github.com/goradd/serve/log"
+)
+
+// These environment variables follow the systemd socket activation protocol. See sd_listen_fds(3).
+const (
+	envListenPid     = "LISTEN_PID"
+	envListenFds     = "LISTEN_FDS"
+	envListenFdNames = "LISTEN_FDNAMES"
+	// envUpgradePpid is set by Upgrade in place of LISTEN_PID, since the parent cannot know the pid of the
+	// child before starting it.
+	envUpgradePpid = "GORADD_UPGRADE_PPID"
+	// envUpgradeReadyFd is the file descriptor of the pipe that a process started by Upgrade writes to once it
+	// is serving.
+	envUpgradeReadyFd = "GORADD_UPGRADE_READY_FD"
+)
+
+// listenFdsStart is the first file descriptor passed by socket activation.
+const listenFdsStart = 3
+
+// inheritedListener is a listening socket passed to this process when it started.
+type inheritedListener struct {
+	name string
+	ln   net.Listener
+}
+
+var inheritOnce sync.Once
+var inheritMu sync.Mutex
+var inherited []*inheritedListener
+
+// readyPipe is the pipe given by the process that started this one with Upgrade, if any. See notifyReady.
+var readyPipe *os.File
+var readyOnce sync.Once
+
+// loadInherited collects the listening sockets that were passed to this process by systemd socket activation
+// or by Upgrade. The environment variables are cleared so that they are not passed on to child processes.
+func loadInherited() {
+	inheritOnce.Do(func() {
+		defer func() {
+			_ = os.Unsetenv(envListenPid)
+			_ = os.Unsetenv(envListenFds)
+			_ = os.Unsetenv(envListenFdNames)
+			_ = os.Unsetenv(envUpgradePpid)
+			_ = os.Unsetenv(envUpgradeReadyFd)
+		}()
+
+		if fd, err := strconv.Atoi(os.Getenv(envUpgradeReadyFd)); err == nil && fd >= listenFdsStart &&
+			os.Getenv(envUpgradePpid) == strconv.Itoa(os.Getppid()) {
+			readyPipe = os.NewFile(uintptr(fd), "upgrade-ready")
+		}
+
+		n, err := strconv.Atoi(os.Getenv(envListenFds))
+		if err != nil || n <= 0 {
+			return
+		}
+		pid := strconv.Itoa(os.Getpid())
+		ppid := strconv.Itoa(os.Getppid())
+		if os.Getenv(envListenPid) != pid && os.Getenv(envUpgradePpid) != ppid {
+			// The sockets were meant for some other process
+			return
+		}
+
+		names := strings.Split(os.Getenv(envListenFdNames), ":")
+		for i := 0; i < n; i++ {
+			var name string
+			if i < len(names) {
+				name = names[i]
+			}
+			inheritFile(os.NewFile(uintptr(listenFdsStart+i), name), name)
+		}
+	})
+}
+
+// inheritFile adds the listening socket f, with the given name, to the inherited listeners, and closes f.
+func inheritFile(f *os.File, name string) {
+	fd := f.Fd()
+	ln, err := net.FileListener(f)
+	_ = f.Close() // FileListener makes its own copy
+	if err != nil {
+		log.Warn(nil, logModule, "Could not use inherited socket",
+			slog.Uint64("fd", uint64(fd)),
+			slog.Any("error", err))
+		return
+	}
+	log.Info(nil, logModule, "Inherited listening socket",
+		slog.String("name", name),
+		slog.String("addr", ln.Addr().String()))
+	inheritMu.Lock()
+	inherited = append(inherited, &inheritedListener{name: name, ln: ln})
+	inheritMu.Unlock()
+}
+
+// takeInherited returns the inherited listener that matches addr and its name, and removes it from the list of
+// available listeners. It returns nil if there is no match.
+//
+// A listener matches if its name, as given by FileDescriptorName in the systemd socket unit, is addr,
+// or if it is listening on the address described by addr.
+func takeInherited(addr string) (net.Listener, string) {
+	loadInherited()
+	inheritMu.Lock()
+	defer inheritMu.Unlock()
+
+	for i, il := range inherited {
+		if il.name == addr || addrMatches(addr, il.ln.Addr()) {
+			inherited = append(inherited[:i], inherited[i+1:]...)
+			return il.ln, il.name
+		}
+	}
+	return nil, ""
+}
+
+// addrMatches returns true if a listener at addr would be listening on a.
+func addrMatches(addr string, a net.Addr) bool {
+	if u, ok := a.(*net.UnixAddr); ok {
+		return addr == unixPrefix+u.Name
+	}
+	tcp, ok := a.(*net.TCPAddr)
+	if !ok {
+		return addr == a.String()
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	p, err := net.LookupPort("tcp", port)
+	if err != nil || p != tcp.Port {
+		return false
+	}
+	if host == "" {
+		return tcp.IP.IsUnspecified()
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.Equal(tcp.IP)
+	}
+	return false
+}
+
+// listen returns a listener for addr, using an inherited socket if one matches, along with the name of the
+// inherited socket. An addr that starts with "unix:" is the path to a unix domain socket.
+func listen(addr string) (net.Listener, string, error) {
+	if ln, name := takeInherited(addr); ln != nil {
+		return ln, name, nil
+	}
+	var ln net.Listener
+	var err error
+	if isUnixAddr(addr) {
+		ln, err = listenUnix(strings.TrimPrefix(addr, unixPrefix))
+	} else {
+		ln, err = net.Listen("tcp", addr)
+	}
+	return ln, "", err
+}
+
+// notifyReady tells the process that started this one with Upgrade that this one is serving, so that the old
+// one can shut down. It does nothing if this process was not started by Upgrade.
+func notifyReady() {
+	loadInherited()
+	readyOnce.Do(func() {
+		if readyPipe != nil {
+			_, _ = readyPipe.Write([]byte{1})
+			_ = readyPipe.Close()
+		}
+	})
+}
+
+// waitReady waits for the new process started by Upgrade to write to the ready pipe. The pipe reaches the end
+// of file without a write if the new process exits first.
+func waitReady(ready *os.File, timeout time.Duration) error {
+	if err := ready.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	b := make([]byte, 1)
+	if _, err := ready.Read(b); err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return fmt.Errorf("the new process did not start serving within %v", timeout)
+		}
+		return errors.New("the new process exited before it started serving")
+	}
+	return nil
+}
+
+// filer is implemented by listeners that can return a copy of their underlying file.
+type filer interface {
+	File() (*os.File, error)
+}
+
+// Upgrade replaces this process with a new copy of the executable without dropping any connections.
+//
+// The new process is started with the listening sockets of all the Runner's started servers, using
+// the same protocol as systemd socket activation. The new process must add listeners with the same
+// addresses for the sockets to be picked up. Once it is serving, the servers of this process are
+// gracefully shut down as in Shutdown, so that requests already in progress can finish. Meanwhile, new
+// connections wait in the shared sockets until the new process accepts them.
+//
+// The new process tells this one that it is serving when its Runner starts its listeners. If it exits before then,
+// like when its configuration is invalid, or does not get there within config.UpgradeReadyTimeout, it is killed,
+// an error is returned, and this process keeps serving.
+//
+// If the executable has been replaced on disk, the new version is started, which makes this a way to deploy
+// new versions without downtime. Under systemd, set NotifyAccess=all or use a PIDFile, since the main process
+// changes. Upgrade is not supported on Windows.
+func (r *Runner) Upgrade(ctx context.Context) (err error) {
+	files, names, unixListeners, err := r.upgradeFiles()
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+		if err != nil {
+			// The upgrade was abandoned, so this process still owns the socket files.
+			for _, u := range unixListeners {
+				u.SetUnlinkOnClose(true)
+			}
+		}
+	}()
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("there are no running listeners to pass to a new process")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = append(os.Environ(),
+		envListenFds+"="+strconv.Itoa(len(files)),
+		envListenFdNames+"="+strings.Join(names, ":"),
+		envUpgradePpid+"="+strconv.Itoa(os.Getpid()),
+		envUpgradeReadyFd+"="+strconv.Itoa(listenFdsStart+len(files)),
+	)
+	err = cmd.Start()
+	_ = readyW.Close() // only the new process may hold the write end, so that its exit ends the pipe
+	if err != nil {
+		return err
+	}
+	log.Info(ctx, logModule, "Started new process, waiting for it to serve",
+		slog.Int("pid", cmd.Process.Pid))
+
+	if err = waitReady(ready, config.UpgradeReadyTimeout); err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return err
+	}
+	log.Info(ctx, logModule, "New process is serving, shutting down the old one",
+		slog.Int("pid", cmd.Process.Pid))
+	_ = cmd.Process.Release()
+
+	return r.Shutdown(ctx)
+}
+
+// upgradeFiles returns copies of the sockets of the running listeners to pass to a new process, along with the
+// names they were inherited with, and the unix listeners that were told to leave their socket files when closed.
+func (r *Runner) upgradeFiles() (files []*os.File, names []string, unixListeners []*net.UnixListener, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, l := range r.listeners {
+		if l.ln == nil {
+			continue
+		}
+		if u, ok := l.ln.(*net.UnixListener); ok {
+			// The new process will take over the socket file, so we must not remove it when we shut down.
+			u.SetUnlinkOnClose(false)
+			unixListeners = append(unixListeners, u)
+		}
+		fl, ok := l.ln.(filer)
+		if !ok {
+			return files, names, unixListeners, fmt.Errorf("the listener at %s cannot be passed to a new process", l.ln.Addr())
+		}
+		f, err := fl.File()
+		if err != nil {
+			return files, names, unixListeners, err
+		}
+		files = append(files, f)
+		names = append(names, l.name)
+	}
+	return
+}
+
+// UpgradeOnSignal calls Upgrade each time this process receives a SIGHUP, until the returned function is called.
+//
+// newContext is called to create the context that limits how long the old process has to drain its
+// connections. If Upgrade fails, the error is logged and the current process keeps running.
+//
+// ReloadOnSignal also defaults to SIGHUP, so if you use both, give ReloadOnSignal a different signal.
+func (r *Runner) UpgradeOnSignal(newContext func() (context.Context, context.CancelFunc)) (stop func()) {
+	c := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-c:
+				ctx, cancel := newContext()
+				if err := r.Upgrade(ctx); err != nil {
+					log.Error(ctx, logModule, "Upgrade failed", slog.Any("error", err))
+				}
+				cancel()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(c)
+		close(done)
+	}
+}
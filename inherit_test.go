@@ -0,0 +1,126 @@
+package serve
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddrMatches(t *testing.T) {
+	any4 := &net.TCPAddr{IP: net.IPv4zero, Port: 8080}
+	any6 := &net.TCPAddr{IP: net.IPv6unspecified, Port: 8080}
+	local := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 443}
+	unix := &net.UnixAddr{Name: "/tmp/app.sock", Net: "unix"}
+
+	tests := []struct {
+		name string
+		addr string
+		a    net.Addr
+		want bool
+	}{
+		{"any ipv4", ":8080", any4, true},
+		{"any ipv6", ":8080", any6, true},
+		{"wrong port", ":8081", any4, false},
+		{"specific to any", "127.0.0.1:8080", any4, false},
+		{"any to specific", ":443", local, false},
+		{"specific", "127.0.0.1:443", local, true},
+		{"named port", "127.0.0.1:https", local, true},
+		{"bad addr", "8080", any4, false},
+		{"unix", "unix:/tmp/app.sock", unix, true},
+		{"unix no prefix", "/tmp/app.sock", unix, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, addrMatches(tt.addr, tt.a))
+		})
+	}
+}
+
+func TestTakeInherited(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	// Pretend the socket was inherited
+	loadInherited()
+	inheritMu.Lock()
+	inherited = append(inherited, &inheritedListener{name: "web", ln: ln})
+	inheritMu.Unlock()
+
+	port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+	ln2, _ := takeInherited("127.0.0.1:1")
+	assert.Nil(t, ln2)
+	ln2, name := takeInherited("127.0.0.1:" + port)
+	assert.Equal(t, ln, ln2)
+	assert.Equal(t, "web", name)
+	ln2, _ = takeInherited("127.0.0.1:" + port)
+	assert.Nil(t, ln2, "a socket can only be taken once")
+	assert.Empty(t, os.Getenv(envListenFds))
+}
+
+// TestUpgradeNames passes a socket that was inherited by name to a new process, as Upgrade does, and checks that
+// the new process can find it by name too.
+func TestUpgradeNames(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	loadInherited()
+	inheritMu.Lock()
+	inherited = append(inherited, &inheritedListener{name: "web", ln: ln})
+	inheritMu.Unlock()
+
+	r := NewRunner()
+	r.AddListener("web", http.NotFoundHandler())
+	go func() { _ = r.ListenAndServe() }()
+	listenerAddr(t, r, 0)
+
+	files, names, _, err := r.upgradeFiles()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web"}, names)
+
+	// The new process
+	assert.NoError(t, r.Shutdown(context.Background()))
+	for i, name := range strings.Split(strings.Join(names, ":"), ":") {
+		inheritFile(files[i], name)
+	}
+	ln2, name := takeInherited("web")
+	require.NotNil(t, ln2, "the socket is found by name after the upgrade")
+	defer ln2.Close()
+	assert.Equal(t, "web", name)
+	assert.Equal(t, ln.Addr().String(), ln2.Addr().String())
+}
+
+func TestWaitReady(t *testing.T) {
+	t.Run("ready", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		assert.NoError(t, err)
+		defer r.Close()
+		readyPipe, readyOnce = w, sync.Once{}
+		defer func() { readyPipe = nil }()
+		notifyReady()
+		notifyReady() // only the first call writes
+		assert.NoError(t, waitReady(r, time.Second))
+	})
+	t.Run("exited", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		assert.NoError(t, err)
+		defer r.Close()
+		_ = w.Close()
+		assert.ErrorContains(t, waitReady(r, time.Second), "exited")
+	})
+	t.Run("timeout", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		assert.NoError(t, err)
+		defer r.Close()
+		defer w.Close()
+		assert.ErrorContains(t, waitReady(r, 50*time.Millisecond), "did not start serving")
+	})
+}
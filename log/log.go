@@ -1,13 +1,49 @@
-// Package log controls how logging
+// Package log controls how logging is done by the serve framework.
 package log
 
 import (
 	"context"
 	"log/slog"
+
+	"github.com/goradd/serve/config"
 )
 
 var logger *slog.Logger
 
+// level is the lowest level of message that will be sent to the logger. It follows config.LogLevel.
+var level slog.LevelVar
+
+func init() {
+	_ = level.UnmarshalText([]byte(config.LogLevel))
+	config.OnChange(func(changes []config.Change) {
+		for _, c := range changes {
+			if c.Name == "LogLevel" {
+				_ = level.UnmarshalText([]byte(c.New.(string)))
+			}
+		}
+	})
+}
+
+type requestIDContext struct{}
+
+// WithRequestID returns a copy of ctx that carries the ID of the request being served.
+// The log functions add the ID to every message logged with the context, as the "request_id" attribute.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContext{}, id)
+}
+
+// RequestID returns the ID of the request that was put in ctx by WithRequestID, or an empty string if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContext{}).(string)
+	return id
+}
+
+// SetLevel sets the lowest level of message that will be sent to the logger. Messages below that level
+// are dropped before they reach the handler of the logger. The default comes from config.LogLevel.
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
 // SetLogger sets the logger that will be used as the destination for all serve framework log calls.
 // The first time this is called, it will enable logging to the logger.
 // All log messages will be passed to the given structured logger, and in groupName.
@@ -25,20 +61,15 @@ func SetLogger(l *slog.Logger, groupName string) {
 // If no logger was set, it will put the error in the "serve" group.
 // It will put the error in the "module" subgroup if present.
 func Error(ctx context.Context, module string, msg string, args ...any) {
-	var l *slog.Logger
-	if logger != nil {
-		l = logger
-	} else {
-		l = logger.WithGroup("serve")
-	}
-	if ctx != nil {
-		ctx = context.Background()
+	if level.Level() > slog.LevelError {
+		return
 	}
-	if module != "" {
-		l.ErrorContext(ctx, msg, slog.Group(module, args))
-	} else {
-		l.ErrorContext(ctx, msg, args)
+	l := logger
+	if l == nil {
+		l = slog.Default().WithGroup("serve")
 	}
+	ctx, args = prepare(ctx, module, args)
+	l.ErrorContext(ctx, msg, args...)
 }
 
 // Warn sends a warning to the logger.
@@ -46,20 +77,15 @@ func Error(ctx context.Context, module string, msg string, args ...any) {
 // In other words, Warn will always send a message to the log if the logging level is set to warning.
 // If no logger was set, it will put the warning in the "serve" group and the "module" subgroup.
 func Warn(ctx context.Context, module string, msg string, args ...any) {
-	var l *slog.Logger
-	if logger != nil {
-		l = logger
-	} else {
-		l = logger.WithGroup("serve")
-	}
-	if ctx != nil {
-		ctx = context.Background()
+	if level.Level() > slog.LevelWarn {
+		return
 	}
-	if module != "" {
-		l.WarnContext(ctx, msg, slog.Group(module, args))
-	} else {
-		l.WarnContext(ctx, msg, args)
+	l := logger
+	if l == nil {
+		l = slog.Default().WithGroup("serve")
 	}
+	ctx, args = prepare(ctx, module, args)
+	l.WarnContext(ctx, msg, args...)
 }
 
 // Info sends an info message to the logger if one has been set.
@@ -67,17 +93,11 @@ func Warn(ctx context.Context, module string, msg string, args ...any) {
 // If ctx is nil, the background context will be used.
 // Set module to the name of the area of the server being debugged, or empty to not include a subgroup.
 func Info(ctx context.Context, module string, msg string, args ...any) {
-	if logger == nil {
+	if logger == nil || level.Level() > slog.LevelInfo {
 		return
 	}
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	if module != "" {
-		logger.InfoContext(ctx, msg, slog.Group(module, args))
-	} else {
-		logger.InfoContext(ctx, msg, args)
-	}
+	ctx, args = prepare(ctx, module, args)
+	logger.InfoContext(ctx, msg, args...)
 }
 
 // Debug sends a debug message to the logger if one has been set.
@@ -85,15 +105,29 @@ func Info(ctx context.Context, module string, msg string, args ...any) {
 // If ctx is nil, the background context will be used.
 // Set module to the name of the area of the server being debugged.
 func Debug(ctx context.Context, module string, msg string, args ...any) {
-	if logger == nil {
+	if logger == nil || level.Level() > slog.LevelDebug {
 		return
 	}
+	ctx, args = prepare(ctx, module, args)
+	logger.DebugContext(ctx, msg, args...)
+}
+
+// prepare puts args in the module subgroup, and adds the request ID from ctx in front of them.
+// A nil ctx is replaced with the background context.
+func prepare(ctx context.Context, module string, args []any) (context.Context, []any) {
 	if ctx == nil {
-		ctx = context.Background()
+		return context.Background(), groupArgs(module, args)
+	}
+	args = groupArgs(module, args)
+	if id := RequestID(ctx); id != "" {
+		args = append([]any{slog.String("request_id", id)}, args...)
 	}
-	if module != "" {
-		logger.DebugContext(ctx, msg, slog.Group(module, args))
-	} else {
-		logger.DebugContext(ctx, msg, args)
+	return ctx, args
+}
+
+func groupArgs(module string, args []any) []any {
+	if module == "" || len(args) == 0 {
+		return args
 	}
+	return []any{slog.Group(module, args...)}
 }
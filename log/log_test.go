@@ -0,0 +1,51 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	var b strings.Builder
+	SetLogger(slog.New(slog.NewTextHandler(&b, &slog.HandlerOptions{Level: slog.LevelDebug})), "")
+	defer func() { logger = nil }()
+	SetLevel(slog.LevelDebug)
+
+	ctx := WithRequestID(context.Background(), "abc123")
+	assert.Equal(t, "abc123", RequestID(ctx))
+	assert.Empty(t, RequestID(context.Background()))
+
+	tests := []struct {
+		name string
+		f    func(ctx context.Context, module string, msg string, args ...any)
+	}{
+		{"error", Error},
+		{"warn", Warn},
+		{"info", Info},
+		{"debug", Debug},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b.Reset()
+			tt.f(ctx, "mod", "hello", slog.String("a", "b"), slog.Int("c", 1))
+			assert.Contains(t, b.String(), `msg=hello serve.request_id=abc123 serve.mod.a=b serve.mod.c=1`)
+
+			b.Reset()
+			tt.f(nil, "", "hello", slog.String("a", "b"))
+			assert.Contains(t, b.String(), `msg=hello serve.a=b`)
+		})
+	}
+}
+
+func TestNoLogger(t *testing.T) {
+	logger = nil
+	assert.NotPanics(t, func() {
+		Error(nil, "mod", "an error")
+		Warn(context.Background(), "mod", "a warning")
+		Info(nil, "mod", "not logged")
+	})
+}
@@ -5,11 +5,18 @@
 package ws
 
 import (
-	"github.com/goradd/serve/log"
+	"context"
+	"fmt"
 	"log/slog"
 	"time"
+
+	"github.com/goradd/serve/log"
+	"github.com/goradd/serve/metrics"
 )
 
+var clientsGauge = metrics.NewGauge("goradd_websocket_clients", "Clients connected to the websocket hub.")
+var channelsGauge = metrics.NewGauge("goradd_websocket_channels", "Channels that websocket clients are subscribed to.")
+
 // clientMessage is the information that is passed to the client for each message
 type clientMessage struct {
 	Channel string `json:"channel"`
@@ -55,6 +62,12 @@ type WebSocketHub struct {
 
 	subscribe chan subscription
 
+	// ping receives a channel that the hub closes to show that it is running.
+	ping chan chan struct{}
+
+	// shutdown receives a channel that the hub closes once it has closed the connections of all its clients.
+	shutdown chan chan struct{}
+
 	// Time to wait for a write to complete
 	WriteWait time.Duration
 
@@ -77,6 +90,8 @@ func NewWebSocketHub() *WebSocketHub {
 		clients:        make(map[string]*Client),
 		channels:       make(map[string]map[string]bool),
 		subscribe:      make(chan subscription),
+		ping:           make(chan chan struct{}),
+		shutdown:       make(chan chan struct{}),
 		WriteWait:      writeWaitDefault,
 		PongWait:       pongWaitDefault,
 		PingPeriod:     pingPeriodDefault,
@@ -114,6 +129,16 @@ func (h *WebSocketHub) run() {
 				log.Debug(nil, logModule, "Could not find channel", slog.Any("message", msg))
 			}
 
+		case reply := <-h.ping:
+			close(reply)
+
+		case reply := <-h.shutdown:
+			for clientID, client := range h.clients {
+				h.unregisterClient(clientID)
+				close(client.send) // the write pump sends a close message and closes the connection
+			}
+			close(reply)
+
 		case sub := <-h.subscribe:
 			log.Debug(nil, logModule, "Subscribing to channel", slog.String("clientId", sub.clientID), slog.String("channel", sub.channel))
 			h.subscribeChannel(sub.clientID, sub.channel)
@@ -130,6 +155,43 @@ func (h *WebSocketHub) run() {
 				}
 			*/
 		}
+		clientsGauge.Set(float64(len(h.clients)))
+		channelsGauge.Set(float64(len(h.channels)))
+	}
+}
+
+// HealthCheck confirms that the hub is running and responding to requests.
+func (h *WebSocketHub) HealthCheck(ctx context.Context) error {
+	reply := make(chan struct{})
+	select {
+	case h.ping <- reply:
+	case <-ctx.Done():
+		return fmt.Errorf("websocket hub is not responding: %w", ctx.Err())
+	}
+	select {
+	case <-reply:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("websocket hub is not responding: %w", ctx.Err())
+	}
+}
+
+// Shutdown closes the connections of all the clients of the hub. The http server does not close websocket
+// connections when it shuts down, so call this after it does, like from an OnShutdown hook.
+//
+// The browsers will try to connect again, so the hub keeps running and accepts new clients until the process exits.
+func (h *WebSocketHub) Shutdown(ctx context.Context) error {
+	reply := make(chan struct{})
+	select {
+	case h.shutdown <- reply:
+	case <-ctx.Done():
+		return fmt.Errorf("websocket hub is not responding: %w", ctx.Err())
+	}
+	select {
+	case <-reply:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("websocket hub is not responding: %w", ctx.Err())
 	}
 }
 
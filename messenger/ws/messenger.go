@@ -10,6 +10,7 @@ import (
 	http2 "github.com/goradd/goradd/pkg/http"
 	_ "github.com/goradd/goradd/pkg/messageServer/ws/assets"
 	"github.com/goradd/html5tag"
+	"github.com/goradd/serve/health"
 )
 
 const logModule = "websocket"
@@ -18,9 +19,11 @@ type WsMessenger struct {
 	hub *WebSocketHub
 }
 
+// Start starts the websocket hub, and registers a readiness check that confirms it is running.
 func (m *WsMessenger) Start() *WebSocketHub {
 	m.hub = NewWebSocketHub()
 	go m.hub.run()
+	health.RegisterReadinessCheck("websocket", 0, m.hub.HealthCheck)
 	return m.hub
 }
 
@@ -0,0 +1,341 @@
+// Package metrics collects measurements of the server and exposes them in the Prometheus text format.
+//
+// Metrics are created with the New* functions, usually in package level variables, and are listed on the
+// page served by Handler. Register the handler with http.RegisterStaticHandler, or call
+// ServerBase.SetupMetrics to register it at config.MetricsPath.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram buckets suited to measuring the time it takes to serve a request, in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metric is implemented by each kind of metric so that the registry can write it.
+type metric interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+var registryMu sync.Mutex
+var registry = make(map[string]metric)
+
+func register(m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[m.name()]; ok {
+		panic(fmt.Sprintf("metric %s is already registered", m.name()))
+	}
+	registry[m.name()] = m
+}
+
+// Unregister removes the named metric, so that it is no longer reported and the name can be used again.
+func Unregister(name string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	delete(registry, name)
+}
+
+// desc is the description shared by all the kinds of metrics.
+type desc struct {
+	n      string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d *desc) name() string {
+	return d.n
+}
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.n, escapeHelp(d.help), d.n, d.kind)
+}
+
+// labelString formats label pairs as they appear in braces after a metric name. extra is added at the end.
+func (d *desc) labelString(values []string, extra ...string) string {
+	if len(d.labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, l := range d.labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", l, escapeLabel(values[i]))
+	}
+	for i := 0; i < len(extra); i += 2 {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", extra[i], escapeLabel(extra[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// key joins label values into a map key, checking that there is the right number of them.
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s takes %d label values, got %d", d.n, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// Counter is a value that only goes up, like the number of requests served.
+// A Counter with labels keeps a separate value for each combination of label values.
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	v      float64
+}
+
+// NewCounter creates and registers a Counter. labels are the names of the labels that the values are split by.
+// It will panic if a metric with the same name has already been registered.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{name, help, "counter", labels}, values: make(map[string]*counterValue)}
+	register(c)
+	return c
+}
+
+// Inc adds one to the counter with the given label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter with the given label values. v must not be negative.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	k := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cv, ok := c.values[k]
+	if !ok {
+		cv = &counterValue{labels: slices.Clone(labelValues)}
+		c.values[k] = cv
+	}
+	cv.v += v
+}
+
+// Value returns the current value of the counter with the given label values.
+func (c *Counter) Value(labelValues ...string) float64 {
+	k := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cv, ok := c.values[k]; ok {
+		return cv.v
+	}
+	return 0
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range sortedKeys(c.values) {
+		cv := c.values[k]
+		fmt.Fprintf(w, "%s%s %s\n", c.n, c.labelString(cv.labels), formatFloat(cv.v))
+	}
+}
+
+// Gauge is a value that can go up and down, like the number of requests in progress.
+// A Gauge with labels keeps a separate value for each combination of label values.
+type Gauge struct {
+	Counter
+}
+
+// NewGauge creates and registers a Gauge. labels are the names of the labels that the values are split by.
+// It will panic if a metric with the same name has already been registered.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{Counter{desc: desc{name, help, "gauge", labels}, values: make(map[string]*counterValue)}}
+	register(g)
+	return g
+}
+
+// Dec subtracts one from the gauge with the given label values.
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+// Set sets the gauge with the given label values to v.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	k := g.key(labelValues)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[k] = &counterValue{labels: slices.Clone(labelValues), v: v}
+}
+
+// funcMetric is a counter or gauge whose value is read from a function each time the metrics are written.
+type funcMetric struct {
+	desc
+	f func() float64
+}
+
+// NewCounterFunc creates and registers a counter whose value is returned by f. Use it to report counts that
+// are kept elsewhere. f must be safe to call from any goroutine.
+func NewCounterFunc(name, help string, f func() float64) {
+	register(&funcMetric{desc{name, help, "counter", nil}, f})
+}
+
+// NewGaugeFunc creates and registers a gauge whose value is returned by f. f must be safe to call from any goroutine.
+func NewGaugeFunc(name, help string, f func() float64) {
+	register(&funcMetric{desc{name, help, "gauge", nil}, f})
+}
+
+func (m *funcMetric) write(w *bufio.Writer) {
+	m.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", m.n, formatFloat(m.f()))
+}
+
+// Histogram counts observations, like the time taken to serve a request, in buckets.
+// A Histogram with labels keeps a separate set of buckets for each combination of label values.
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64 // not cumulative, the last one is for the +Inf bucket
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram creates and registers a Histogram with the given upper bounds of the buckets. If buckets is nil,
+// DefaultBuckets are used. It will panic if a metric with the same name has already been registered.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	h := &Histogram{desc: desc{name, help, "histogram", labels}, buckets: buckets, values: make(map[string]*histogramValue)}
+	register(h)
+	return h
+}
+
+// Observe records v in the histogram with the given label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	k := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hv, ok := h.values[k]
+	if !ok {
+		hv = &histogramValue{labels: slices.Clone(labelValues), counts: make([]uint64, len(h.buckets)+1)}
+		h.values[k] = hv
+	}
+	i, _ := slices.BinarySearch(h.buckets, v)
+	hv.counts[i]++
+	hv.sum += v
+	hv.count++
+}
+
+// Count returns the number of observations recorded in the histogram with the given label values.
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	k := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if hv, ok := h.values[k]; ok {
+		return hv.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, k := range sortedKeys(h.values) {
+		hv := h.values[k]
+		var cumulative uint64
+		for i, c := range hv.counts {
+			cumulative += c
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = formatFloat(h.buckets[i])
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.n, h.labelString(hv.labels, "le", le), cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.n, h.labelString(hv.labels), formatFloat(hv.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.n, h.labelString(hv.labels), hv.count)
+	}
+}
+
+// Write writes all the registered metrics to w in the Prometheus text exposition format.
+func Write(w io.Writer) error {
+	registryMu.Lock()
+	names := make([]string, 0, len(registry))
+	for n := range registry {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, len(names))
+	for i, n := range names {
+		metrics[i] = registry[n]
+	}
+	registryMu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler returns a handler that serves the registered metrics in the Prometheus text exposition format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = Write(w)
+	})
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
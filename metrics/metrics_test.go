@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+	c := NewCounter("test_requests_total", "Requests.\nWith a second line.", "path")
+	defer Unregister("test_requests_total")
+	g := NewGauge("test_in_flight", "In flight.")
+	defer Unregister("test_in_flight")
+	h := NewHistogram("test_duration_seconds", "Duration.", []float64{1, 0.5}, "op")
+	defer Unregister("test_duration_seconds")
+	NewGaugeFunc("test_func", "Func.", func() float64 { return 42 })
+	defer Unregister("test_func")
+
+	c.Inc(`/a"b`)
+	c.Add(2, "/c")
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	h.Observe(0.2, "load")
+	h.Observe(0.7, "load")
+	h.Observe(3, "load")
+
+	assert.Equal(t, 2.0, c.Value("/c"))
+	assert.Equal(t, 1.0, g.Value())
+	assert.Equal(t, uint64(3), h.Count("load"))
+
+	var b strings.Builder
+	assert.NoError(t, Write(&b))
+	out := b.String()
+	assert.Contains(t, out, `# HELP test_requests_total Requests.\nWith a second line.
+# TYPE test_requests_total counter
+test_requests_total{path="/a\"b"} 1
+test_requests_total{path="/c"} 2
+`)
+	assert.Contains(t, out, "# TYPE test_in_flight gauge\ntest_in_flight 1\n")
+	assert.Contains(t, out, `# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{op="load",le="0.5"} 1
+test_duration_seconds_bucket{op="load",le="1"} 2
+test_duration_seconds_bucket{op="load",le="+Inf"} 3
+test_duration_seconds_sum{op="load"} 3.9
+test_duration_seconds_count{op="load"} 3
+`)
+	assert.Contains(t, out, "test_func 42\n")
+	assert.Less(t, strings.Index(out, "test_duration_seconds"), strings.Index(out, "test_in_flight"), "metrics are sorted by name")
+}
+
+func TestRegister(t *testing.T) {
+	NewCounter("test_dup", "Dup.")
+	defer Unregister("test_dup")
+	assert.Panics(t, func() { NewGauge("test_dup", "Dup.") })
+
+	c := NewCounter("test_labels", "Labels.", "a", "b")
+	defer Unregister("test_labels")
+	assert.Panics(t, func() { c.Inc("x") })
+}
+
+func TestHandler(t *testing.T) {
+	NewCounter("test_handler", "Handler.").Inc()
+	defer Unregister("test_handler")
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "test_handler 1\n")
+}
@@ -0,0 +1,53 @@
+package parse
+
+import (
+	"testing"
+)
+
+func TestAddNonce(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "script and style",
+			html: `<html><head><style>p {color:red}</style><script src="a.js"></script></head>
+<body><SCRIPT>go()</SCRIPT></body></html>`,
+			want: `<html><head><style nonce="abc">p {color:red}</style><script nonce="abc" src="a.js"></script></head>
+<body><SCRIPT nonce="abc">go()</SCRIPT></body></html>`,
+		},
+		{
+			name: "existing nonce",
+			html: `<script nonce="xyz">a()</script><script>b()</script>`,
+			want: `<script nonce="xyz">a()</script><script nonce="abc">b()</script>`,
+		},
+		{
+			name: "tags in script text and attributes",
+			html: `<div title="<script>"></div><script>let s = "<style>";</script>`,
+			want: `<div title="<script>"></div><script nonce="abc">let s = "<style>";</script>`,
+		},
+		{
+			name: "opted out",
+			html: `<script data-no-nonce>a()</script><script>b()</script>`,
+			want: `<script data-no-nonce>a()</script><script nonce="abc">b()</script>`,
+		},
+		{
+			name: "nothing to change",
+			html: `<p>Hello</p>`,
+			want: `<p>Hello</p>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AddNonce([]byte(tt.html), "abc")
+			if err != nil {
+				t.Fatalf("AddNonce() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("AddNonce() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
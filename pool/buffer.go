@@ -3,8 +3,10 @@ package pool
 import (
 	"bytes"
 	"github.com/goradd/serve/log"
+	"github.com/goradd/serve/metrics"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 )
 
 // BufferPoolI describes a buffer pool that can be used to improve memory allocation and garbage collection for
@@ -29,28 +31,39 @@ type pool struct {
 	sync.Pool
 }
 
+// Counts of the use of the pools made by this package, which includes the default BufferPool, reported by
+// the metrics package. A BufferPool of your own is not counted.
+var hits, misses, discards atomic.Uint64
+
+func init() {
+	metrics.NewCounterFunc("goradd_buffer_pool_hits_total", "Buffers taken from the pool that were reused.",
+		func() float64 { return float64(hits.Load()) })
+	metrics.NewCounterFunc("goradd_buffer_pool_misses_total", "Buffers taken from the pool that had to be allocated.",
+		func() float64 { return float64(misses.Load()) })
+	metrics.NewCounterFunc("goradd_buffer_pool_discards_total", "Buffers not put back in the pool because they were bigger than MaxBufferSize.",
+		func() float64 { return float64(discards.Load()) })
+}
+
 // TODO: Test and improve the allocation mechanism here under heavy load. We could potentially run out of memory
 // so we should attempt to limit how much memory the pool is allowed to hold on to. The sync.Pool documentation
 // says that the fmt package has an example of how to use pool such that it scales under heavy load, but
 // releases memory when quiet.
 
 func newPool() *pool {
-	p := pool{
-		sync.Pool{
-			New: func() interface{} {
-				return new(bytes.Buffer)
-			},
-		},
-	}
-
-	return &p
+	return new(pool)
 }
 
 // GetBuffer returns a buffer from the pool if one is available, or creates a new one if all the buffers are being used.
 // Generally, you should follow a GetBuffer with a deferred PutBuffer, and the PutBuffer should be in the same
 // function as the GetBuffer to prevent memory leaks.
 func (p *pool) GetBuffer() *bytes.Buffer {
-	return p.Get().(*bytes.Buffer)
+	// The pool has no New function, so that a buffer that had to be allocated can be counted as a miss
+	if b, ok := p.Get().(*bytes.Buffer); ok {
+		hits.Add(1)
+		return b
+	}
+	misses.Add(1)
+	return new(bytes.Buffer)
 }
 
 // PutBuffer returns a buffer to the buffer pool. Always do this after you are done with a buffer. If the buffer
@@ -64,6 +77,7 @@ func (p *pool) PutBuffer(buffer *bytes.Buffer) {
 		// Log when our buffer is bigger than MaxBufferSize. If this is happening a lot the value should be increased.
 		// This might happen when serving large files through the buffered server.
 		// Consider bypassing the buffered server and serving them directly instead.
+		discards.Add(1)
 		log.Info(nil, "ppol", "Buffer size was bigger than MaxBufferSize.",
 			slog.Any("Buffer.Cap", buffer.Cap()))
 	}
@@ -18,3 +18,16 @@ func Test_Pool(t *testing.T) {
 	b.WriteString("12345678901234567890")
 	PutBuffer(b)
 }
+
+func Test_PoolCounts(t *testing.T) {
+	MaxBufferSize = 20000
+	p := newPool()
+	h, m := hits.Load(), misses.Load()
+	p.PutBuffer(p.GetBuffer())
+	assert.Equal(t, m+1, misses.Load(), "the first buffer is allocated")
+	for range 9 {
+		p.PutBuffer(p.GetBuffer())
+	}
+	// sync.Pool may drop buffers, so only the total is certain
+	assert.Equal(t, h+m+10, hits.Load()+misses.Load())
+}
@@ -0,0 +1,59 @@
+package serve
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/goradd/serve/config"
+	"github.com/goradd/serve/log"
+)
+
+// ReloadOnSignal calls config.Reload each time this process receives one of the given signals,
+// until the returned function is called. If no signals are given, SIGHUP is used.
+//
+// The changes and any errors are logged. If the reload fails, none of the settings are changed.
+//
+// UpgradeOnSignal uses SIGHUP, so if you use both, pass a different signal here, like syscall.SIGUSR1.
+// Otherwise, one SIGHUP would both reload the configuration and start a new process.
+func ReloadOnSignal(sigs ...os.Signal) (stop func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+	c := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(c, sigs...)
+	go func() {
+		for {
+			select {
+			case <-c:
+				reloadConfig()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(c)
+		close(done)
+	}
+}
+
+func reloadConfig() {
+	changes, err := config.Reload()
+	if err != nil {
+		log.Error(nil, logModule, "Could not reload the configuration", slog.Any("error", err))
+		return
+	}
+	if len(changes) == 0 {
+		log.Info(nil, logModule, "Reloaded the configuration, nothing changed")
+		return
+	}
+	for _, c := range changes {
+		log.Info(nil, logModule, "Configuration changed",
+			slog.String("name", c.Name),
+			slog.Any("old", c.Old),
+			slog.Any("new", c.New))
+	}
+}
@@ -0,0 +1,182 @@
+package serve
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/goradd/serve/config"
+	"github.com/goradd/serve/log"
+)
+
+// HookFunc is a function that is called when the application starts or shuts down.
+//
+// The context will be cancelled when the hook's timeout expires. Return an error to report a problem.
+type HookFunc func(ctx context.Context) error
+
+type hook struct {
+	name    string
+	order   int
+	timeout time.Duration
+	f       HookFunc
+}
+
+var hooksMu sync.Mutex
+var startHooks []hook
+var shutdownHooks []hook
+
+// OnStart registers a function that Run will call before it starts the servers.
+//
+// Hooks are called one at a time from the lowest order to the highest. Hooks with the same order are called
+// in the order they were registered. If timeout is not zero, the context passed to the hook will be cancelled
+// after that amount of time. name is used to identify the hook in errors.
+//
+// If any start hook returns an error, the servers are not started and the shutdown hooks are called.
+// You may call this from an init() function.
+func OnStart(name string, order int, timeout time.Duration, f HookFunc) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	startHooks = append(startHooks, hook{name, order, timeout, f})
+}
+
+// OnShutdown registers a function that Run will call after the servers have shut down. Use it to stop
+// background services, flush sessions, close database connections and so on.
+//
+// Hooks are called one at a time from the lowest order to the highest. Hooks with the same order are called
+// in the order they were registered. If timeout is not zero, the context passed to the hook will be cancelled
+// after that amount of time. All the shutdown hooks are called even if some of them fail.
+// You may call this from an init() function.
+func OnShutdown(name string, order int, timeout time.Duration, f HookFunc) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	shutdownHooks = append(shutdownHooks, hook{name, order, timeout, f})
+}
+
+// Run starts the servers of r and manages the lifecycle of the application, returning the exit code
+// the application should exit with.
+//
+// Run calls the OnStart hooks, starts the servers, and then waits for a SIGINT or SIGTERM, or for
+// one of the servers to fail. It then gracefully shuts down the servers, giving them config.ShutdownTimeout
+// to finish the requests in progress, and calls the OnShutdown hooks.
+//
+// Run only stops the servers. The other services of the application are stopped by the OnShutdown hooks.
+// ServerBase registers the hooks of the services it sets up: SetupMessenger closes the websocket connections,
+// SetupSessionManager stops the memory session store, and SetupTracing flushes the spans waiting to be sent. The application must register hooks for everything else,
+// including the services that replace the ones ServerBase sets up, like a session store of its own.
+//
+// All the errors are logged. If there were none, zero is returned. Otherwise, one is returned.
+// Typical use is:
+//
+//	func main() {
+//		r := serve.NewRunner()
+//		r.AddListener(":8080", handler)
+//		os.Exit(serve.Run(r))
+//	}
+func Run(r *Runner) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var errs []error
+
+	if err := runHooks(ctx, "start", sortedHooks(&startHooks)); err != nil {
+		errs = append(errs, err)
+	} else {
+		serveErr := make(chan error, 1)
+		go func() {
+			serveErr <- r.ListenAndServe()
+		}()
+
+		select {
+		case <-ctx.Done():
+			log.Info(nil, logModule, "Received signal, shutting down")
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Value(&config.ShutdownTimeout))
+			if err := r.Shutdown(shutdownCtx); err != nil {
+				errs = append(errs, fmt.Errorf("shutdown: %w", err))
+			}
+			cancel()
+			if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs = append(errs, err)
+			}
+		case err := <-serveErr:
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	stop() // a second signal now will kill the process as normal
+
+	if err := runHooks(context.Background(), "shutdown", sortedHooks(&shutdownHooks)); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		log.Error(nil, logModule, "Application stopped with errors", slog.Any("error", err))
+		return 1
+	}
+	return 0
+}
+
+// sortedHooks returns a copy of hooks sorted by order.
+func sortedHooks(hooks *[]hook) []hook {
+	hooksMu.Lock()
+	h := slices.Clone(*hooks)
+	hooksMu.Unlock()
+	slices.SortStableFunc(h, func(a, b hook) int {
+		return cmp.Compare(a.order, b.order)
+	})
+	return h
+}
+
+// runHooks calls the given hooks in order. If phase is "start", it stops at the first error. Otherwise,
+// all the hooks are called and all the errors are returned.
+func runHooks(ctx context.Context, phase string, hooks []hook) error {
+	var errs []error
+	for _, h := range hooks {
+		if err := runHook(ctx, h); err != nil {
+			err = fmt.Errorf("%s hook %q: %w", phase, h.name, err)
+			errs = append(errs, err)
+			if phase == "start" {
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runHook calls one hook, converting a panic into an error.
+//
+// If the hook does not return by the time its context is done, it is abandoned and the context's error is returned.
+func runHook(ctx context.Context, h hook) error {
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	log.Debug(ctx, logModule, "Calling hook", slog.String("name", h.name))
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- h.f(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,84 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/goradd/serve/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	log.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)), "")
+}
+
+func resetHooks() {
+	startHooks = nil
+	shutdownHooks = nil
+}
+
+func TestRun(t *testing.T) {
+	defer resetHooks()
+	var calls []string
+	record := func(name string) HookFunc {
+		return func(ctx context.Context) error {
+			calls = append(calls, name)
+			return nil
+		}
+	}
+	OnStart("db", 10, 0, record("start db"))
+	OnStart("cache", 5, 0, record("start cache"))
+	OnShutdown("db", 10, 0, record("stop db"))
+	OnShutdown("sessions", 0, 0, record("flush sessions"))
+	OnShutdown("hub", 0, 0, record("stop hub"))
+
+	r := NewRunner()
+	r.AddListener("127.0.0.1:0", http.NotFoundHandler())
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	}()
+	assert.Equal(t, 0, Run(r))
+	assert.Equal(t, []string{"start cache", "start db", "flush sessions", "stop hub", "stop db"}, calls)
+}
+
+func TestRun_StartError(t *testing.T) {
+	defer resetHooks()
+	var stopped bool
+	OnStart("fail", 0, 0, func(ctx context.Context) error { return errors.New("no database") })
+	OnStart("never", 1, 0, func(ctx context.Context) error {
+		t.Error("hook after a failure should not be called")
+		return nil
+	})
+	OnShutdown("stop", 0, 0, func(ctx context.Context) error {
+		stopped = true
+		return nil
+	})
+
+	r := NewRunner()
+	r.AddListener("127.0.0.1:0", http.NotFoundHandler())
+	assert.Equal(t, 1, Run(r))
+	assert.True(t, stopped)
+}
+
+func TestRunHooks(t *testing.T) {
+	hooks := []hook{
+		{"error", 0, 0, func(ctx context.Context) error { return errors.New("error") }},
+		{"panic", 0, 0, func(ctx context.Context) error { panic("panic") }},
+		{"timeout", 0, 10 * time.Millisecond, func(ctx context.Context) error {
+			time.Sleep(time.Second)
+			return nil
+		}},
+	}
+	err := runHooks(context.Background(), "shutdown", hooks)
+	assert.ErrorContains(t, err, `shutdown hook "error": error`)
+	assert.ErrorContains(t, err, `shutdown hook "panic": panic: panic`)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
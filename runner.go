@@ -0,0 +1,326 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goradd/serve/cert"
+	"github.com/goradd/serve/config"
+	"github.com/goradd/serve/health"
+	http2 "github.com/goradd/serve/http"
+)
+
+// Runner starts and stops a group of web servers together.
+//
+// A typical production setup will serve the application over HTTPS, and use a second listener
+// on the plain HTTP port that only redirects browsers to the HTTPS port. Add each listener
+// to the Runner, call ListenAndServe to start them all, and call Shutdown to gracefully
+// stop them all at once.
+//
+// A listener address is either a TCP address like ":8080", or the path to a unix domain socket
+// prefixed with "unix:", like "unix:/run/app/app.sock". A unix domain socket is a good choice when the
+// application is behind a web server like nginx on the same host. See config.UnixSocketMode for the socket's
+// permissions.
+//
+// Each server created by the Runner gets its timeout values from the global variables defined in config.
+// The connections of all the servers are tracked by the Runner's ConnTracker.
+type Runner struct {
+	mu        sync.Mutex
+	listeners []*listener
+	conns     *ConnTracker
+}
+
+// listener is one server managed by a Runner.
+type listener struct {
+	server  *http.Server
+	certs   *cert.Manager
+	secure  bool
+	started bool
+	// ln is the network listener the server is serving, once started.
+	ln net.Listener
+	// name is the name that ln was inherited with, if any, which Upgrade passes on to the new process.
+	name string
+}
+
+// NewRunner returns a new Runner with no listeners.
+func NewRunner() *Runner {
+	return &Runner{conns: NewConnTracker()}
+}
+
+// Connections returns the tracker that counts the connections of all the Runner's servers.
+func (r *Runner) Connections() *ConnTracker {
+	return r.conns
+}
+
+// defaultRunner is the Runner used by the package level ListenAndServe functions.
+var defaultRunner = NewRunner()
+
+// AddListener adds a plain HTTP listener at addr that will send requests to handler.
+//
+// The new server is returned so that you can further customize it before calling ListenAndServe.
+func (r *Runner) AddListener(addr string, handler http.Handler) *http.Server {
+	return r.add(&listener{server: newServer(addr, handler)}).server
+}
+
+// AddTLSListener adds an HTTPS listener at addr that will send requests to handler,
+// using the certificate and key found in certFile and keyFile.
+//
+// While the server is running, the files are watched for changes and reloaded, so that renewed
+// certificates are served without a restart.
+//
+// An error is returned if either file does not exist or they do not hold a valid pair.
+// Otherwise, the new server is returned so that you can further customize it before calling ListenAndServe.
+func (r *Runner) AddTLSListener(addr, certFile, keyFile string, handler http.Handler) (*http.Server, error) {
+	l, err := newTLSListener(addr, certFile, keyFile, handler)
+	if err != nil {
+		return nil, err
+	}
+	return r.add(l).server, nil
+}
+
+// AddCertManagerListener adds an HTTPS listener at addr that will send requests to handler,
+// using the certificates served by m.
+//
+// Use this to serve multiple certificates from one listener. m will be watched for changes
+// while the server is running.
+func (r *Runner) AddCertManagerListener(addr string, m *cert.Manager, handler http.Handler) *http.Server {
+	s := newServer(addr, handler)
+	s.TLSConfig = m.TLSConfig()
+	return r.add(&listener{server: s, certs: m, secure: true}).server
+}
+
+// AddRedirectListener adds a plain HTTP listener at addr that does nothing but permanently redirect
+// every request to the same location using HTTPS.
+//
+// httpsPort is the port the HTTPS listener is using. Leave it blank if that is the default port of 443.
+// If config.ProxyPath is set, requests outside of that path are redirected to the matching path
+// inside of it.
+func (r *Runner) AddRedirectListener(addr string, httpsPort string) *http.Server {
+	return r.AddListener(addr, redirectHandler(httpsPort))
+}
+
+func (r *Runner) add(l *listener) *listener {
+	if l.server.ConnState == nil {
+		l.server.ConnState = r.conns.ConnState
+	}
+	r.mu.Lock()
+	r.listeners = append(r.listeners, l)
+	r.mu.Unlock()
+	return l
+}
+
+// ListenAndServe starts all the listeners that have not already been started and blocks until they
+// all have stopped.
+//
+// If this process was given listening sockets through systemd socket activation or Upgrade, a socket
+// that matches the address of a listener is used instead of opening a new one.
+//
+// All addresses are opened before any of the servers start, so if one of them cannot be opened,
+// none will be served and the error is returned. If one of the servers stops on its own with an error,
+// the others are closed as well.
+//
+// Like http.Server.ListenAndServe, http.ErrServerClosed is returned after a call to Shutdown.
+// Otherwise, the errors of all the servers that failed are returned.
+func (r *Runner) ListenAndServe() error {
+	r.mu.Lock()
+	listeners := make([]*listener, len(r.listeners))
+	copy(listeners, r.listeners)
+	r.mu.Unlock()
+	return r.listenAndServe(listeners)
+}
+
+// listenAndServe starts the given listeners that have not already been started.
+func (r *Runner) listenAndServe(listeners []*listener) error {
+	r.mu.Lock()
+	var toStart []*listener
+	var netListeners []net.Listener
+	var names []string
+	for _, l := range listeners {
+		if l.started {
+			continue
+		}
+		addr := l.server.Addr
+		if addr == "" {
+			if l.isTLS() {
+				addr = ":https"
+			} else {
+				addr = ":http"
+			}
+		}
+		ln, name, err := listen(addr)
+		if err != nil {
+			for _, ln2 := range netListeners {
+				_ = ln2.Close()
+			}
+			r.mu.Unlock()
+			return err
+		}
+		toStart = append(toStart, l)
+		netListeners = append(netListeners, ln)
+		names = append(names, name)
+	}
+	for i, l := range toStart {
+		l.started = true
+		l.ln = netListeners[i]
+		l.name = names[i]
+	}
+	r.mu.Unlock()
+
+	if len(toStart) == 0 {
+		return errors.New("there are no listeners to start")
+	}
+	// The sockets are open, and connections wait in them until the servers accept them
+	notifyReady()
+
+	errs := make([]error, len(toStart))
+	var wg sync.WaitGroup
+	for i, l := range toStart {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := l.serve()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs[i] = err
+				// One server failed, so take the rest of the group down with it
+				for _, l2 := range toStart {
+					if l2 != l {
+						_ = l2.server.Close()
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	return http.ErrServerClosed
+}
+
+// Shutdown gracefully shuts down all the listeners of the Runner, returning any errors found.
+//
+// The readiness endpoint starts failing right away. If config.ShutdownDrainDelay is set, the servers
+// keep accepting requests for that amount of time so that load balancers can react first.
+//
+// The listeners are shut down concurrently, so ctx limits the time allowed for the whole group
+// to finish draining. The connection counts are logged at the start of the shutdown, and again
+// if connections are still open when ctx is done.
+func (r *Runner) Shutdown(ctx context.Context) error {
+	health.SetShuttingDown()
+	if delay := config.Value(&config.ShutdownDrainDelay); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+	r.conns.Log(ctx, "Shutting down")
+
+	r.mu.Lock()
+	listeners := make([]*listener, len(r.listeners))
+	copy(listeners, r.listeners)
+	r.mu.Unlock()
+
+	errs := make([]error, len(listeners))
+	var wg sync.WaitGroup
+	for i, l := range listeners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = l.server.Shutdown(ctx)
+		}()
+	}
+	wg.Wait()
+	err := errors.Join(errs...)
+	if err != nil {
+		r.conns.Log(context.Background(), "Connections still open at end of shutdown")
+	}
+	return err
+}
+
+func (l *listener) isTLS() bool {
+	return l.secure
+}
+
+func (l *listener) serve() error {
+	if l.certs != nil {
+		l.certs.Watch(0)
+		defer l.certs.StopWatching()
+	}
+	if l.isTLS() {
+		// The certificates come from the TLSConfig
+		return l.server.ServeTLS(l.ln, "", "")
+	}
+	return l.server.Serve(l.ln)
+}
+
+// newTLSListener returns a listener that serves the certificate and key pair found in certFile and keyFile.
+func newTLSListener(addr, certFile, keyFile string, handler http.Handler) (*listener, error) {
+	// Here we confirm that the CertFile and KeyFile exist. If they don't, the error from
+	// loading them will not tell you which file is missing.
+	if !pathExists(certFile) {
+		return nil, fmt.Errorf("TLSCertFile does not exist: %s", certFile)
+	}
+	if !pathExists(keyFile) {
+		return nil, fmt.Errorf("TLSKeyFile does not exist: %s", keyFile)
+	}
+	m := cert.NewManager()
+	if err := m.AddPair(certFile, keyFile); err != nil {
+		return nil, err
+	}
+	s := newServer(addr, handler)
+	s.TLSConfig = m.TLSConfig()
+	return &listener{server: s, certs: m, secure: true}, nil
+}
+
+// newServer returns an http.Server with timeout values taken from config.
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		ReadTimeout:       config.ReadTimeout,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		Handler:           handler,
+	}
+}
+
+// redirectHandler returns a handler that permanently redirects requests to the HTTPS server at httpsPort.
+func redirectHandler(httpsPort string) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			host = strings.Trim(host, "[]")
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]" // ipv6 address without a port
+		}
+
+		p := r.URL.EscapedPath() // the decoded path would lose escapes like %2F, and is not a valid url part
+		if p == "" {
+			p = "/"
+		}
+		if config.ProxyPath != "" &&
+			p != config.ProxyPath &&
+			!strings.HasPrefix(p, config.ProxyPath+"/") {
+			p = http2.MakeLocalPath(p)
+		}
+
+		u := "https://" + host + p
+		if r.URL.RawQuery != "" {
+			u += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, u, http.StatusMovedPermanently)
+	}
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,72 @@
+package serve
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goradd/serve/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		proxyPath string
+		httpsPort string
+		target    string
+		want      string
+	}{
+		{"default port", "", "", "http://example.com/a/b?c=d", "https://example.com/a/b?c=d"},
+		{"strip port", "", "", "http://example.com:8080/a", "https://example.com/a"},
+		{"https port", "", "8443", "http://example.com:8080/a", "https://example.com:8443/a"},
+		{"ipv6", "", "", "http://[::1]:8080/a", "https://[::1]/a"},
+		{"ipv6 https port", "", "8443", "http://[::1]/a", "https://[::1]:8443/a"},
+		{"proxy path", "/app", "", "http://example.com/app/a", "https://example.com/app/a"},
+		{"proxy path root", "/app", "", "http://example.com/app", "https://example.com/app"},
+		{"outside proxy path", "/app", "", "http://example.com/a", "https://example.com/app/a"},
+		{"escaped path", "", "", "http://example.com/a%2Fb/c%20d?q=a%26b", "https://example.com/a%2Fb/c%20d?q=a%26b"},
+		{"escaped path under proxy path", "/app", "", "http://example.com/a%3Fb", "https://example.com/app/a%3Fb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.ProxyPath = tt.proxyPath
+			defer func() { config.ProxyPath = "" }()
+
+			req := httptest.NewRequest("GET", tt.target, nil)
+			w := httptest.NewRecorder()
+			redirectHandler(tt.httpsPort).ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusMovedPermanently, w.Code)
+			assert.Equal(t, tt.want, w.Header().Get("Location"))
+		})
+	}
+}
+
+func TestRunner_Shutdown(t *testing.T) {
+	r := NewRunner()
+	r.AddListener("127.0.0.1:0", http.NotFoundHandler())
+	r.AddRedirectListener("127.0.0.1:0", "")
+
+	done := make(chan error)
+	go func() {
+		done <- r.ListenAndServe()
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	assert.NoError(t, r.Shutdown(context.Background()))
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, http.ErrServerClosed)
+	case <-time.After(time.Second):
+		t.Error("ListenAndServe did not return after Shutdown")
+	}
+}
+
+func TestRunner_ListenError(t *testing.T) {
+	r := NewRunner()
+	r.AddListener("127.0.0.1:-1", http.NotFoundHandler())
+	assert.Error(t, r.ListenAndServe())
+}
@@ -1,16 +1,24 @@
 package serve
 
 import (
+	"context"
 	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/alexedwards/scs/v2/memstore"
 	"github.com/goradd/goradd/pkg/messageServer"
-	"github.com/goradd/goradd/pkg/messageServer/ws"
 	"github.com/goradd/serve/config"
+	"github.com/goradd/serve/health"
 	http2 "github.com/goradd/serve/http"
+	"github.com/goradd/serve/messenger"
+	"github.com/goradd/serve/messenger/ws"
+	"github.com/goradd/serve/metrics"
 	"github.com/goradd/serve/session"
+	"github.com/goradd/serve/trace"
 )
 
 // ServerBaseI defines the virtual functions that are callable on the Server.
@@ -34,40 +42,229 @@ type ServerBaseI interface {
 	*/
 }
 
+// Names of the stages in the default handler pipeline made by ServerBase.MakePipeline.
+const (
+	StageTrustedProxies  = "trustedProxies"
+	StageTracing         = "tracing"
+	StageRequestID       = "requestID"
+	StageAccessLog       = "accessLog"
+	StageStats           = "stats"
+	StageHsts            = "hsts"
+	StageSecurityHeaders = "securityHeaders"
+	StageErrorHandler    = "errorHandler"
+	StageHeaderValidator = "headerValidator"
+	StageCORS            = "cors"
+	StageLimits          = "limits"
+	StageStaticDeadline  = "staticDeadline"
+	StagePatternMuxer    = "patternMuxer"
+	StageMaintenance     = "maintenance"
+	StageBufferedOutput  = "bufferedOutput"
+	StageCSPNonce        = "cspNonce"
+	StageAppDeadline     = "appDeadline"
+	StageSession         = "session"
+	StageRateLimits      = "rateLimits"
+	StageCSRF            = "csrf"
+	StageAppMuxer        = "appMuxer"
+)
+
 type ServerBase struct {
 	// HstsMaxAge sets the HSTS timeout length in seconds.
 	// Set this to -1 to turn off HSTS, or 0 to reset it.
+	//
+	// Init sets the HSTS values from the config package. They are read when the handler is made,
+	// and after that, they follow the changes made by config.Reload, unless you changed them from the values
+	// set by Init.
 	HstsMaxAge            int64
 	HstsIncludeSubdomains bool
 	HstsPreload           bool
 
+	// SecurityHeaders are the security headers sent with each response, or nil to send none.
+	//
+	// Init sets them from http.DefaultSecurityHeaders and the config package. They are read when the handler is made,
+	// and after that, the Content-Security-Policy follows the changes made by config.Reload, unless you changed it from
+	// the policy set by Init.
+	// Use http.RegisterSecurityHeaders to send different headers for some paths.
+	SecurityHeaders *http2.SecurityHeaders
+
 	SessionHandler session.ManagerI
+
+	// AccessLog records the requests served, if it is not nil. Init sets it up from config.AccessLogFormat.
+	// Set its Writer to send the log somewhere other than the log package.
+	AccessLog *http2.AccessLogger
+
+	// Pipeline is the list of middleware stages that MakeHandler turns into the handler stack.
+	// Init fills it with the default stages. Add, replace or remove stages after calling Init
+	// and before calling MakeHandler.
+	Pipeline *http2.Pipeline
+
+	hsts            atomic.Pointer[hstsValues]
+	securityHeaders atomic.Pointer[http2.SecurityHeaders]
+	maintenance     atomic.Bool
+
+	subscribeOnce sync.Once
+	configMu      sync.Mutex // keeps calls of configChanged from overlapping
+}
+
+type hstsValues struct {
+	maxAge            int64
+	includeSubdomains bool
+	preload           bool
 }
 
 func (a *ServerBase) Init() {
-	a.HstsMaxAge = 86400 // one day
-	a.HstsIncludeSubdomains = true
-	a.HstsPreload = false
+	a.HstsMaxAge = config.Value(&config.HstsMaxAge)
+	a.HstsIncludeSubdomains = config.Value(&config.HstsIncludeSubdomains)
+	a.HstsPreload = config.Value(&config.HstsPreload)
+	a.maintenance.Store(config.Value(&config.Maintenance))
+	if config.SecurityHeaders {
+		h := http2.DefaultSecurityHeaders
+		a.SecurityHeaders = &h
+		applyCSPConfig(a.SecurityHeaders)
+	}
+	if config.AccessLogFormat != "" {
+		format, err := http2.ParseAccessLogFormat(config.AccessLogFormat)
+		if err != nil {
+			panic(err)
+		}
+		a.AccessLog = http2.NewAccessLogger(format, nil)
+		a.AccessLog.ExcludePrefixes = config.AccessLogExclude
+	}
+	a.Pipeline = a.MakePipeline()
+	a.subscribeOnce.Do(func() {
+		config.OnChange(a.configChanged)
+	})
 }
 
-func (a *ServerBase) MakeHandler() http.Handler {
-	// the handler chain gets built in the reverse order of getting called
-
-	// These handlers are called in reverse order
-	h := http.NotFoundHandler() // Should go at the end of the chain to catch whatever is missed
-	h = http2.WithAppMuxer(h)   // Serves other dynamic files, and possibly the api
-	//	h = a.ServePageHandler(h)           // Serves the Goradd dynamic pages
-	h = a.WithSession(h)
-	h = http2.WithBufferedOutput(h) // Must be in front of the session handler
-	//	h = a.StatsHandler(h)
-	h = http2.WithPatternMuxer(h) // Serves most static files and websocket requests.
-	// Must be after the error handler so panics are intercepted by the error reporter
-	// and must be in front of the buffered output handler because of the websocket server
-	h = http2.WithHeaderValidator(h)
-	h = http2.WithErrorHandler(h) // Default http error handler to intercept panics.
-	h = a.WithHsts(h)
-	//	h = a.this().AccessLogHandler(h)
+// configChanged applies the settings that can be changed while the server is running.
+//
+// A value is only changed if it still holds the old value of the setting, so that the values the application
+// set on a after Init are kept. Values are only changed once the handler has been made.
+func (a *ServerBase) configChanged(changes []config.Change) {
+	a.configMu.Lock()
+	defer a.configMu.Unlock()
+	for _, c := range changes {
+		switch c.Name {
+		case "HstsMaxAge", "HstsIncludeSubdomains", "HstsPreload":
+			cur := a.hsts.Load()
+			if cur == nil {
+				continue
+			}
+			h := *cur
+			switch c.Name {
+			case "HstsMaxAge":
+				if h.maxAge == c.Old.(int64) {
+					h.maxAge = c.New.(int64)
+				}
+			case "HstsIncludeSubdomains":
+				if h.includeSubdomains == c.Old.(bool) {
+					h.includeSubdomains = c.New.(bool)
+				}
+			case "HstsPreload":
+				if h.preload == c.Old.(bool) {
+					h.preload = c.New.(bool)
+				}
+			}
+			a.hsts.Store(&h)
+		case "Maintenance":
+			a.maintenance.Store(c.New.(bool))
+		case "ContentSecurityPolicy", "CSPReportOnly":
+			cur := a.securityHeaders.Load()
+			if cur == nil {
+				continue
+			}
+			h := *cur
+			if c.Name == "ContentSecurityPolicy" {
+				if h.ContentSecurityPolicy == cspPolicy(c.Old.(string)) {
+					h.ContentSecurityPolicy = cspPolicy(c.New.(string))
+				}
+			} else if h.CSPReportOnly == c.Old.(bool) {
+				h.CSPReportOnly = c.New.(bool)
+			}
+			a.securityHeaders.Store(&h)
+		}
+	}
+}
 
+// MakePipeline returns the default handler pipeline, with the stages in the order a request visits them.
+//
+// The pipeline also carries the rules about the order of the stages, so that if you move a stage to a place
+// where it cannot work, MakeHandler will tell you.
+func (a *ServerBase) MakePipeline() *http2.Pipeline {
+	p := http2.NewPipeline(
+		http2.Stage{Name: StageTrustedProxies, Middleware: http2.WithTrustedProxies}, // Reads the forwarding headers of trusted proxies
+		http2.Stage{Name: StageTracing, Middleware: http2.WithTracing},               // Starts the span of the request, see SetupTracing
+		http2.Stage{Name: StageRequestID, Middleware: http2.WithRequestID},           // Ties the log messages of a request together
+		http2.Stage{Name: StageAccessLog, Middleware: a.WithAccessLog},
+		http2.Stage{Name: StageStats, Middleware: http2.WithStats}, // Request metrics, see SetupMetrics
+		http2.Stage{Name: StageHsts, Middleware: a.WithHsts},
+		http2.Stage{Name: StageSecurityHeaders, Middleware: a.WithSecurityHeaders},
+		http2.Stage{Name: StageErrorHandler, Middleware: http2.WithErrorHandler}, // Default http error handler to intercept panics.
+		http2.Stage{Name: StageHeaderValidator, Middleware: http2.WithHeaderValidator},
+		http2.Stage{Name: StageCORS, Middleware: http2.WithCORS},     // Policies registered with http.RegisterCORSPolicy
+		http2.Stage{Name: StageLimits, Middleware: http2.WithLimits}, // Sheds load before the output buffers are taken.
+		http2.Stage{Name: StageStaticDeadline, Middleware: http2.WithStaticDeadlines},
+		http2.Stage{Name: StagePatternMuxer, Middleware: http2.WithPatternMuxer}, // Serves most static files and websocket requests.
+		http2.Stage{Name: StageMaintenance, Middleware: a.WithMaintenance},
+		http2.Stage{Name: StageBufferedOutput, Middleware: http2.WithBufferedOutput},
+		http2.Stage{Name: StageCSPNonce, Middleware: http2.WithCSPNonce},        // Adds nonces to the inline scripts, see config.CSPNonce
+		http2.Stage{Name: StageAppDeadline, Middleware: http2.WithAppDeadlines}, // Deadlines registered with http.RegisterDeadline
+		http2.Stage{Name: StageSession, Middleware: a.WithSession},
+		http2.Stage{Name: StageRateLimits, Middleware: http2.WithRateLimits}, // Limits registered with http.RegisterRateLimit
+		http2.Stage{Name: StageCSRF, Middleware: http2.WithCSRF},             // Checks the CSRF token of posts, see config.CSRF
+		//	ServePageHandler, which serves the Goradd dynamic pages
+		http2.Stage{Name: StageAppMuxer, Middleware: http2.WithAppMuxer}, // Serves other dynamic files, and possibly the api
+	)
+	p.Require(StageTrustedProxies, StageAccessLog,
+		"the access log must record the address of the client, not of the proxy")
+	p.Require(StageTrustedProxies, StageRateLimits,
+		"rate limits keyed by IP address must count the client, not the proxy")
+	p.Require(StageTracing, StageRequestID,
+		"the spans of the other stages must be children of the span of the request")
+	p.Require(StageRequestID, StageAccessLog,
+		"the access log records the request ID")
+	p.Require(StageRequestID, StageErrorHandler,
+		"the errors logged by the error handler need the request ID")
+	p.Require(StageErrorHandler, StagePatternMuxer,
+		"the error handler must intercept panics from the static handlers")
+	p.Require(StagePatternMuxer, StageBufferedOutput,
+		"the websocket server cannot work behind buffered output")
+	p.Require(StageBufferedOutput, StageSession,
+		"the session handler writes headers after the output has been written")
+	p.Require(StageLimits, StageBufferedOutput,
+		"requests must be let in before they take an output buffer")
+	p.Require(StageSession, StageRateLimits,
+		"rate limits keyed by session need the session token")
+	p.Require(StageStaticDeadline, StagePatternMuxer,
+		"the deadline must be set before the static handlers are called")
+	p.Require(StageStats, StageErrorHandler,
+		"the stats must count the errors made from panics")
+	p.Require(StageBufferedOutput, StageAppDeadline,
+		"the deadline handler replaces the buffered output when the deadline passes")
+	p.Require(StageBufferedOutput, StageCSPNonce,
+		"the nonces are added to the buffered output")
+	p.Require(StageSecurityHeaders, StageCSPNonce,
+		"the nonce is added to the Content-Security-Policy set by the security headers")
+	p.Require(StageSession, StageCSRF,
+		"the CSRF secret is kept in the session")
+	p.Require(StageErrorHandler, StageCSRF,
+		"the CSRF check rejects requests by panicking with an http.Error")
+	p.Require(StageCORS, StagePatternMuxer,
+		"preflight requests must be answered before they reach the handlers")
+	return p
+}
+
+// MakeHandler builds the handler stack out of the stages in a.Pipeline.
+//
+// It will panic if the order of the stages breaks one of the rules of the pipeline.
+func (a *ServerBase) MakeHandler() http.Handler {
+	if a.Pipeline == nil {
+		a.Pipeline = a.MakePipeline()
+	}
+	// Not found should go at the end of the chain to catch whatever is missed
+	h, err := a.Pipeline.Build(http.NotFoundHandler())
+	if err != nil {
+		panic(err)
+	}
 	return h
 }
 
@@ -84,18 +281,84 @@ func (a *ServerBase) MakeHandler() http.Handler {
 // specified, even if the header is not sent again. However, you can override it by sending another header, and
 // clear it by setting the timeout to 0. Set the timeout to -1 to turn it off.
 func (a *ServerBase) WithHsts(next http.Handler) http.Handler {
+	a.hsts.Store(&hstsValues{a.HstsMaxAge, a.HstsIncludeSubdomains, a.HstsPreload})
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		if a.HstsMaxAge >= 0 {
-			http2.WriteHstsHeader(w, a.HstsMaxAge, a.HstsIncludeSubdomains, a.HstsPreload)
+		if h := a.hsts.Load(); h.maxAge >= 0 {
+			http2.WriteHstsHeader(w, h.maxAge, h.includeSubdomains, h.preload)
 		}
 		next.ServeHTTP(w, r)
 	}
 	return http.HandlerFunc(fn)
 }
 
+// WithSecurityHeaders adds the headers in a.SecurityHeaders to each response, or the headers registered with
+// http.RegisterSecurityHeaders for the path of the request. It does nothing if a.SecurityHeaders is nil.
+func (a *ServerBase) WithSecurityHeaders(next http.Handler) http.Handler {
+	if a.SecurityHeaders == nil {
+		return next
+	}
+	h := *a.SecurityHeaders
+	a.securityHeaders.Store(&h)
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if h, ok := http2.SecurityHeadersFor(r.URL.Path); ok {
+			h.Write(w)
+		} else {
+			a.securityHeaders.Load().Write(w)
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// applyCSPConfig copies the Content-Security-Policy settings of the config package into h.
+func applyCSPConfig(h *http2.SecurityHeaders) {
+	h.ContentSecurityPolicy = cspPolicy(config.Value(&config.ContentSecurityPolicy))
+	h.CSPReportOnly = config.Value(&config.CSPReportOnly)
+}
+
+// cspPolicy returns the policy sent for a value of config.ContentSecurityPolicy.
+// A blank value means the policy of http.DefaultSecurityHeaders.
+func cspPolicy(p string) string {
+	if p == "" {
+		return http2.DefaultSecurityHeaders.ContentSecurityPolicy
+	}
+	return p
+}
+
+// WithMaintenance answers requests with a 503 Service Unavailable error while config.Maintenance is on.
+//
+// It comes after the PatternMuxer in the default pipeline, so that static files, websockets and the health endpoints
+// are still served. Turn maintenance mode on and off with config.Reload.
+func (a *ServerBase) WithMaintenance(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if a.maintenance.Load() {
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "The site is down for maintenance. Please try again later.", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// WithAccessLog puts the access logger into the handler stack, if a.AccessLog is set.
+func (a *ServerBase) WithAccessLog(next http.Handler) http.Handler {
+	if a.AccessLog == nil {
+		return next
+	}
+	return a.AccessLog.Use(next)
+}
+
 // WithSession puts the session handling middleware into the handler stack
 func (a *ServerBase) WithSession(next http.Handler) http.Handler {
-	return a.SessionHandler.Use(next)
+	// Tell the access log which session the request belongs to
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if e := http2.AccessLogEntryFromContext(r.Context()); e != nil {
+			e.SessionID = session.LogID(r.Context())
+		}
+		next.ServeHTTP(w, r)
+	}
+	return a.SessionHandler.Use(http.HandlerFunc(fn))
 }
 
 // SetupSessionManager sets up the global session manager. The session can be used to save data that is specific to a user
@@ -105,7 +368,9 @@ func (a *ServerBase) WithSession(next http.Handler) http.Handler {
 // The default uses a 3rd party session manager, stores the session in memory, and tracks sessions using cookies.
 // This setup is useful for development, testing, debugging, and for moderately used websites.
 // However, this default does not scale, so if you are launching multiple copies of the app in production,
-// you should override this with a scalable storage mechanism.
+// you should override this with a scalable storage mechanism. The store is stopped by an OnShutdown hook if it has a
+// StopCleanup method, like the stores of the scs package do. If your store needs something else, register
+// an OnShutdown hook of your own.
 func (a *ServerBase) SetupSessionManager() {
 	s := scs.New()
 	store := memstore.NewWithCleanupInterval(24 * time.Hour) // replace this with a different store if desired
@@ -116,14 +381,74 @@ func (a *ServerBase) SetupSessionManager() {
 	sm := session.NewScsManager(s)
 	sm.(session.ScsManager).SessionManager.IdleTimeout = 6 * time.Hour
 	a.SessionHandler = sm
+	health.RegisterReadinessCheck("sessions", 0, sm.(session.ScsManager).HealthCheck)
+	// The servers are shut down before this is called, so no request is still using the store.
+	// The store is looked up then, so that a store put in its place is stopped instead.
+	OnShutdown("sessions", 90, 5*time.Second, func(ctx context.Context) error {
+		if c, ok := s.Store.(interface{ StopCleanup() }); ok {
+			c.StopCleanup()
+		}
+		return nil
+	})
+}
+
+// SetupHealthChecks registers the liveness and readiness endpoints at config.HealthPath and config.ReadyPath.
+//
+// The endpoints are served by the PatternMuxer, so they do not go through the session manager or output buffering.
+// Register the checks for your own subsystems, like databases, with health.RegisterReadinessCheck.
+func (a *ServerBase) SetupHealthChecks() {
+	if config.HealthPath != "" {
+		http2.RegisterStaticHandler(config.HealthPath, health.LivenessHandler())
+	}
+	if config.ReadyPath != "" {
+		http2.RegisterStaticHandler(config.ReadyPath, health.ReadinessHandler())
+	}
+}
+
+// SetupMetrics registers the endpoint that serves the metrics of the server at config.MetricsPath,
+// if it is not blank.
+//
+// Like the health endpoints, it is served by the PatternMuxer. Add the metrics of your own subsystems with
+// the functions of the metrics package.
+func (a *ServerBase) SetupMetrics() {
+	if config.MetricsPath != "" {
+		http2.RegisterStaticHandler(config.MetricsPath, metrics.Handler())
+	}
+}
+
+// SetupTracing turns on tracing if config.TraceExporter is set, sending the spans to the standard output
+// or to the OpenTelemetry collector at config.TraceEndpoint. The spans waiting to be sent are flushed
+// by an OnShutdown hook.
+//
+// It must be called before MakeHandler. To use another exporter or tracing library, call trace.SetTracer instead.
+func (a *ServerBase) SetupTracing() {
+	var exporter trace.Exporter
+	switch config.TraceExporter {
+	case "":
+		return
+	case "stdout":
+		exporter = trace.NewJSONExporter(os.Stdout)
+	case "otlp":
+		exporter = trace.NewOTLPExporter(config.TraceEndpoint, config.TraceServiceName)
+	default:
+		panic("unknown trace exporter " + config.TraceExporter)
+	}
+	t := trace.NewBatchTracer(exporter, config.TraceSampleRatio)
+	trace.SetTracer(t)
+	OnShutdown("tracing", 100, 10*time.Second, t.Shutdown)
 }
 
 // SetupMessenger injects the global messenger that permits pub/sub communication between the server and client.
 //
+// The default is a websocket based messenger appropriate for development and single-server applications. It is
+// used by both the messenger package and the goradd framework. Its hub is checked by the readiness endpoint,
+// and an OnShutdown hook closes the websocket connections, which the servers do not close when they shut down.
+//
 // You can use this mechanism to set up your own messaging system for application use too.
 func (a *ServerBase) SetupMessenger() {
-	// The default sets up a websocket based messenger appropriate for development and single-server applications
-	messenger := new(ws.WsMessenger)
-	messageServer.Messenger = messenger
-	messenger.Start()
+	m := new(ws.WsMessenger)
+	messenger.Messenger = m
+	messageServer.Messenger = m
+	hub := m.Start()
+	OnShutdown("websocket", 80, 5*time.Second, hub.Shutdown)
 }
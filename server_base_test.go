@@ -0,0 +1,135 @@
+package serve
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alexedwards/scs/v2/memstore"
+	"github.com/goradd/serve/config"
+	http2 "github.com/goradd/serve/http"
+	"github.com/goradd/serve/messenger"
+	"github.com/goradd/serve/messenger/ws"
+	"github.com/goradd/serve/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerBase_MakeHandler(t *testing.T) {
+	a := new(ServerBase)
+	a.Init()
+	a.SessionHandler = session.NewMock()
+	assert.NotPanics(t, func() { a.MakeHandler() })
+
+	// move buffered output behind the session handler
+	a.Pipeline.Remove(StageBufferedOutput)
+	a.Pipeline.InsertAfter(StageSession, StageBufferedOutput, http2.WithBufferedOutput)
+	assert.Panics(t, func() { a.MakeHandler() })
+}
+
+func TestServerBase_ConfigChanged(t *testing.T) {
+	a := new(ServerBase)
+	a.Init()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := a.WithHsts(a.WithMaintenance(ok))
+
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w
+	}
+	w := serve()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "max-age=86400; includeSubDomains", w.Header().Get("Strict-Transport-Security"))
+
+	defer func() { config.HstsMaxAge = 86400 }()
+	config.HstsMaxAge = 100
+	a.configChanged([]config.Change{
+		{Name: "HstsMaxAge", Old: int64(86400), New: int64(100)},
+		{Name: "Maintenance", Old: false, New: true},
+	})
+	w = serve()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	assert.Equal(t, "max-age=100; includeSubDomains", w.Header().Get("Strict-Transport-Security"))
+}
+
+func TestServerBase_ConfigChangedKeepsAppValues(t *testing.T) {
+	a := new(ServerBase)
+	a.Init()
+	a.Init() // only subscribes once
+	a.HstsMaxAge = 500
+	h := a.WithHsts(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	defer func() { config.HstsMaxAge, config.HstsPreload = 86400, false }()
+	config.HstsMaxAge, config.HstsPreload = 100, true
+	a.configChanged([]config.Change{
+		{Name: "HstsMaxAge", Old: int64(86400), New: int64(100)},
+		{Name: "HstsPreload", Old: false, New: true},
+	})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, "max-age=500; includeSubDomains; preload", w.Header().Get("Strict-Transport-Security"),
+		"the max age set by the application is kept")
+}
+
+func TestServerBase_SecurityHeaders(t *testing.T) {
+	a := new(ServerBase)
+	a.Init()
+	h := a.WithSecurityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http2.DefaultSecurityHeaders.ContentSecurityPolicy, w.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+
+	defer func() { config.ContentSecurityPolicy, config.CSPReportOnly = "", false }()
+	config.ContentSecurityPolicy, config.CSPReportOnly = "default-src 'self'", true
+	a.configChanged([]config.Change{
+		{Name: "ContentSecurityPolicy", Old: "", New: config.ContentSecurityPolicy},
+		{Name: "CSPReportOnly", Old: false, New: true},
+	})
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Empty(t, w.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, "default-src 'self'", w.Header().Get("Content-Security-Policy-Report-Only"))
+}
+
+// cleanupStore is a session store that records whether its cleanup was stopped.
+type cleanupStore struct {
+	*memstore.MemStore
+	stopped bool
+}
+
+func (s *cleanupStore) StopCleanup() {
+	s.stopped = true
+}
+
+func TestServerBase_SetupSessionManagerShutdownHook(t *testing.T) {
+	defer resetHooks()
+	a := new(ServerBase)
+	a.SetupSessionManager()
+	// The memory store races with a StopCleanup that comes right after it is made, so use a store of our own
+	store := &cleanupStore{MemStore: memstore.New()}
+	a.SessionHandler.(session.ScsManager).Store = store
+
+	hooks := sortedHooks(&shutdownHooks)
+	if assert.Len(t, hooks, 1) {
+		assert.Equal(t, "sessions", hooks[0].name)
+		assert.NoError(t, runHooks(context.Background(), "shutdown", hooks))
+		assert.True(t, store.stopped)
+	}
+}
+
+func TestServerBase_SetupMessenger(t *testing.T) {
+	defer resetHooks()
+	a := new(ServerBase)
+	a.SetupMessenger()
+	defer func() { messenger.Messenger = nil }()
+	assert.IsType(t, new(ws.WsMessenger), messenger.Messenger)
+	hooks := sortedHooks(&shutdownHooks)
+	if assert.Len(t, hooks, 1) {
+		assert.Equal(t, "websocket", hooks[0].name)
+		assert.NoError(t, runHooks(context.Background(), "shutdown", hooks))
+	}
+}
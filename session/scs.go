@@ -3,13 +3,20 @@ package session
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/goradd/serve/log"
+	"github.com/goradd/serve/metrics"
+	"github.com/goradd/serve/trace"
 )
 
 const scsSessionDataKey = "goradd.data"
 
+var storeLatency = metrics.NewHistogram("goradd_session_store_duration_seconds",
+	"Time taken to load sessions from and commit them to the session store.",
+	[]float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}, "operation")
+
 // ScsManager implements the ManagerI interface for the github.com/alexedwards/scs session manager.
 //
 // Note that this manager does post-processing on the response writer, including
@@ -23,6 +30,18 @@ func NewScsManager(mgr *scs.SessionManager) ManagerI {
 	return ScsManager{mgr}
 }
 
+// HealthCheck confirms that the session store is responding by looking up a session that does not exist.
+func (mgr ScsManager) HealthCheck(ctx context.Context) error {
+	const token = "goradd-health-check"
+	var err error
+	if s, ok := mgr.SessionManager.Store.(scs.CtxStore); ok {
+		_, _, err = s.FindCtx(ctx, token)
+	} else {
+		_, _, err = mgr.SessionManager.Store.Find(token)
+	}
+	return err
+}
+
 // Use is an http handler that wraps the session management process. It will get and put session data
 // into the http context.
 func (mgr ScsManager) Use(next http.Handler) http.Handler {
@@ -36,7 +55,12 @@ func (mgr ScsManager) Use(next http.Handler) http.Handler {
 			token = cookie.Value
 		}
 
+		start := time.Now()
+		_, span := trace.Start(r.Context(), "session.load", trace.SpanKindInternal)
 		ctx, err := mgr.SessionManager.Load(r.Context(), token)
+		span.SetError(err)
+		span.End()
+		storeLatency.Observe(time.Since(start).Seconds(), "load")
 
 		if err != nil {
 			panic("error loading or unpacking session: " + err.Error())
@@ -52,6 +76,7 @@ func (mgr ScsManager) Use(next http.Handler) http.Handler {
 		}
 
 		ctx = context.WithValue(ctx, sessionContext{}, sess)
+		ctx = WithToken(ctx, mgr.SessionManager.Token(ctx))
 		r = r.WithContext(ctx)
 		next.ServeHTTP(w, r)
 
@@ -72,7 +97,12 @@ func (mgr ScsManager) Use(next http.Handler) http.Handler {
 
 		if sess.data.Len() > 0 {
 			mgr.SessionManager.Put(ctx, scsSessionDataKey, sess)
+			start = time.Now()
+			_, span = trace.Start(ctx, "session.commit", trace.SpanKindInternal)
 			token2, expiry, err := mgr.SessionManager.Commit(ctx)
+			span.SetError(err)
+			span.End()
+			storeLatency.Observe(time.Since(start).Seconds(), "commit")
 			if err != nil {
 				panic("Error marshalling session data: " + err.Error())
 				return
@@ -2,13 +2,16 @@ package session
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/gob"
+	"encoding/hex"
 	"net/http"
 
 	"github.com/goradd/maps"
 )
 
 type sessionContext struct{}
+type sessionTokenContext struct{}
 
 const sessionResetKey string = "goradd.reset"
 const timezoneKey string = "goradd.timezone"
@@ -56,6 +59,32 @@ func HasSession(ctx context.Context) bool {
 	return ctx.Value(sessionContext{}) != nil
 }
 
+// WithToken returns a context that holds the token that identifies the current session, like the value of the
+// session cookie. Session managers call this so that middleware can find the token with Token.
+func WithToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, sessionTokenContext{}, token)
+}
+
+// Token returns the token that identifies the session of the request, or an empty string if the request
+// did not come with a session that the session manager knows about.
+//
+// Treat the token like a password. Anyone that has it can use the session.
+func Token(ctx context.Context) string {
+	t, _ := ctx.Value(sessionTokenContext{}).(string)
+	return t
+}
+
+// LogID returns a short identifier of the session of the request that is safe to write to logs, since the token
+// cannot be worked out from it. It returns an empty string if the request has no session token.
+func LogID(ctx context.Context) string {
+	t := Token(ctx)
+	if t == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(t))
+	return hex.EncodeToString(sum[:8])
+}
+
 // Has returns true if the given key exists in the session store
 func Has(ctx context.Context, key string) bool {
 	return getSession(ctx).data.Has(key)
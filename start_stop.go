@@ -2,42 +2,40 @@ package serve
 
 import (
 	"context"
-	"log"
 	"net/http"
 	"os"
 
+	"github.com/goradd/serve/cert"
 	"github.com/goradd/serve/config"
 )
 
-var server *http.Server
-
 // ListenAndServeTLSWithTimeouts starts a secure web server with timeouts. The default http server does
 // not have timeouts by default, which leaves the server open to certain attacks that would start
 // a connection, but then very slowly read or write. Timeout values are taken from global variables
 // defined in config, which you can set at init time.
+//
+// The certificate and key files are watched for changes while the server is running, and reloaded
+// when they change, so renewed certificates are picked up without a restart. An error is
+// returned if the files do not exist or do not hold a valid certificate and key pair.
+//
+// During development, you may leave certFile and keyFile blank to serve a certificate signed by a local
+// development certificate authority. See cert.DevPair.
+//
+// You may call this and ListenAndServeWithTimeouts multiple times from different goroutines to serve
+// on multiple addresses. Shutdown will stop all of them. See Runner for more control over groups of servers.
 func ListenAndServeTLSWithTimeouts(addr, certFile, keyFile string, handler http.Handler) error {
-	// Here we confirm that the CertFile and KeyFile exist. If they don't, ListenAndServeTLS just exit with an open error
-	// and you will not know why.
-	if !pathExists(certFile) {
-		log.Fatalf("TLSCertFile does not exist: %s", certFile)
-	}
-
-	if !pathExists(keyFile) {
-		log.Fatalf("TLSKeyFile does not exist: %s", keyFile)
+	if certFile == "" && keyFile == "" && !config.Release {
+		var err error
+		if certFile, keyFile, err = cert.DevPair(); err != nil {
+			return err
+		}
 	}
-
-	// TODO: https://blog.gopheracademy.com/advent-2016/exposing-go-on-the-internet/ recommends keeping track
-	// of open connections using the ConnState hook for debugging purposes.
-
-	server = &http.Server{
-		Addr:              addr,
-		ReadTimeout:       config.ReadTimeout,
-		ReadHeaderTimeout: config.ReadHeaderTimeout,
-		WriteTimeout:      config.WriteTimeout,
-		IdleTimeout:       config.IdleTimeout,
-		Handler:           handler,
+	l, err := newTLSListener(addr, certFile, keyFile, handler)
+	if err != nil {
+		return err
 	}
-	return server.ListenAndServeTLS(certFile, keyFile)
+	defaultRunner.add(l)
+	return defaultRunner.listenAndServe([]*listener{l})
 }
 
 // ListenAndServeWithTimeouts starts a web server with timeouts. The default http server does
@@ -45,25 +43,59 @@ func ListenAndServeTLSWithTimeouts(addr, certFile, keyFile string, handler http.
 // a connection, but then very slowly read or write. Timeout values are taken from global variables
 // defined in config, which you can set at init time. This non-secure version is appropriate
 // if you are serving behind another server, like apache or nginx.
+//
+// To serve on a unix domain socket instead of a TCP port, give an addr like "unix:/run/app/app.sock".
+//
+// You may call this and ListenAndServeTLSWithTimeouts multiple times from different goroutines to serve
+// on multiple addresses. Shutdown will stop all of them. See Runner for more control over groups of servers.
 func ListenAndServeWithTimeouts(addr string, handler http.Handler) error {
+	l := defaultRunner.add(&listener{server: newServer(addr, handler)})
+	return defaultRunner.listenAndServe([]*listener{l})
+}
 
-	// TODO: https://blog.gopheracademy.com/advent-2016/exposing-go-on-the-internet/ recommends keeping track
-	// of open connections using the ConnState hook for debugging purposes.
-
-	server = &http.Server{
-		Addr:              addr,
-		ReadTimeout:       config.ReadTimeout,
-		ReadHeaderTimeout: config.ReadHeaderTimeout,
-		WriteTimeout:      config.WriteTimeout,
-		IdleTimeout:       config.IdleTimeout,
-		Handler:           handler,
+// ListenAndServeH2CWithTimeouts starts a web server with timeouts that accepts cleartext HTTP/2 (h2c) as well
+// as HTTP/1.1. Use this instead of ListenAndServeWithTimeouts if you are serving behind a proxy that
+// talks HTTP/2 to its backends. See Runner.AddH2CListener.
+func ListenAndServeH2CWithTimeouts(addr string, handler http.Handler) error {
+	l := &listener{server: newServer(addr, handler)}
+	if err := l.configureH2C(); err != nil {
+		return err
 	}
-	return server.ListenAndServe()
+	defaultRunner.add(l)
+	return defaultRunner.listenAndServe([]*listener{l})
 }
 
-// Shutdown performs a graceful shutdown of the server, returning any errors found.
+// ListenAndServeRedirect starts a web server that permanently redirects all requests to the same
+// location using HTTPS. Use it alongside ListenAndServeTLSWithTimeouts to send browsers that
+// arrive on the plain HTTP port over to the secure server.
+//
+// httpsPort is the port of the secure server, or blank if it is the default port of 443.
+func ListenAndServeRedirect(addr string, httpsPort string) error {
+	l := defaultRunner.add(&listener{server: newServer(addr, redirectHandler(httpsPort))})
+	return defaultRunner.listenAndServe([]*listener{l})
+}
+
+// Shutdown performs a graceful shutdown of all the servers started by the package level
+// ListenAndServe functions, returning any errors found.
 func Shutdown(ctx context.Context) error {
-	return server.Shutdown(ctx)
+	return defaultRunner.Shutdown(ctx)
+}
+
+// Upgrade replaces this process with a new copy of the executable, passing it the listening sockets of the
+// servers started by the package level ListenAndServe functions. See Runner.Upgrade.
+func Upgrade(ctx context.Context) error {
+	return defaultRunner.Upgrade(ctx)
+}
+
+// UpgradeOnSignal calls Upgrade each time this process receives a SIGHUP. See Runner.UpgradeOnSignal.
+func UpgradeOnSignal(newContext func() (context.Context, context.CancelFunc)) (stop func()) {
+	return defaultRunner.UpgradeOnSignal(newContext)
+}
+
+// Connections returns the tracker that counts the connections of the servers started by the package level
+// ListenAndServe functions.
+func Connections() *ConnTracker {
+	return defaultRunner.Connections()
 }
 
 func pathExists(path string) bool {
@@ -0,0 +1,250 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// JSONExporter writes each span as a JSON object on its own line. Point it at os.Stdout to watch the spans
+// during development.
+type JSONExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONExporter returns a JSONExporter that writes to w.
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{w: w}
+}
+
+type jsonSpan struct {
+	Name          string         `json:"name"`
+	TraceID       string         `json:"trace_id"`
+	SpanID        string         `json:"span_id"`
+	ParentID      string         `json:"parent_id,omitempty"`
+	Kind          string         `json:"kind"`
+	Start         time.Time      `json:"start"`
+	Duration      time.Duration  `json:"duration_ns"`
+	Attributes    map[string]any `json:"attributes,omitempty"`
+	Status        string         `json:"status,omitempty"`
+	StatusMessage string         `json:"status_message,omitempty"`
+}
+
+// Export writes the spans to the writer.
+func (e *JSONExporter) Export(_ context.Context, spans []SpanData) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, s := range spans {
+		j := jsonSpan{
+			Name:          s.Name,
+			TraceID:       s.SpanContext.TraceID.String(),
+			SpanID:        s.SpanContext.SpanID.String(),
+			Kind:          s.Kind.String(),
+			Start:         s.Start,
+			Duration:      s.End.Sub(s.Start),
+			StatusMessage: s.StatusMessage,
+		}
+		if s.Parent.IsValid() {
+			j.ParentID = s.Parent.String()
+		}
+		switch s.Status {
+		case StatusOK:
+			j.Status = "ok"
+		case StatusError:
+			j.Status = "error"
+		}
+		if len(s.Attrs) > 0 {
+			j.Attributes = make(map[string]any, len(s.Attrs))
+			flatten("", s.Attrs, func(k string, v slog.Value) {
+				if v.Kind() == slog.KindAny {
+					j.Attributes[k] = v.String() // values like errors do not marshal well
+				} else {
+					j.Attributes[k] = v.Any()
+				}
+			})
+		}
+		if err := enc.Encode(j); err != nil {
+			return err
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+// flatten calls f for each attribute, giving the attributes of groups keys joined with dots.
+func flatten(prefix string, attrs []slog.Attr, f func(key string, v slog.Value)) {
+	for _, a := range attrs {
+		v := a.Value.Resolve()
+		if v.Kind() == slog.KindGroup {
+			flatten(prefix+a.Key+".", v.Group(), f)
+		} else {
+			f(prefix+a.Key, v)
+		}
+	}
+}
+
+// OTLPExporter sends spans to an OpenTelemetry collector with the OTLP/HTTP protocol, using the JSON encoding.
+type OTLPExporter struct {
+	// Endpoint is the url of the traces endpoint of the collector, like "http://localhost:4318/v1/traces".
+	Endpoint string
+	// ServiceName is sent as the service.name attribute of the resource, which names the application in the traces.
+	ServiceName string
+	// Headers are added to each request, like an authorization header needed by a hosted collector.
+	Headers map[string]string
+	// Client sends the requests. NewOTLPExporter sets it to a client with a 10-second timeout.
+	// If it is nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// NewOTLPExporter returns an OTLPExporter that sends spans to endpoint.
+func NewOTLPExporter(endpoint, serviceName string) *OTLPExporter {
+	return &OTLPExporter{
+		Endpoint:    endpoint,
+		ServiceName: serviceName,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// The types below are the parts of the OTLP ExportTraceServiceRequest message that the exporter uses,
+// in the JSON mapping of protobuf. Trace and span IDs are hex, and 64-bit integers are strings.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	TraceState        string         `json:"traceState,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func otlpAttr(k string, v slog.Value) otlpKeyValue {
+	var o otlpValue
+	switch v.Kind() {
+	case slog.KindBool:
+		b := v.Bool()
+		o.BoolValue = &b
+	case slog.KindInt64:
+		i := strconv.FormatInt(v.Int64(), 10)
+		o.IntValue = &i
+	case slog.KindUint64:
+		i := strconv.FormatUint(v.Uint64(), 10)
+		o.IntValue = &i
+	case slog.KindDuration:
+		i := strconv.FormatInt(v.Duration().Nanoseconds(), 10)
+		o.IntValue = &i
+	case slog.KindFloat64:
+		f := v.Float64()
+		o.DoubleValue = &f
+	default:
+		s := v.String()
+		o.StringValue = &s
+	}
+	return otlpKeyValue{k, o}
+}
+
+// Export sends the spans to the collector.
+func (e *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	ss := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		o := otlpSpan{
+			TraceID:           s.SpanContext.TraceID.String(),
+			SpanID:            s.SpanContext.SpanID.String(),
+			TraceState:        s.SpanContext.TraceState,
+			Name:              s.Name,
+			Kind:              int(s.Kind),
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Status:            otlpStatus{Code: int(s.Status), Message: s.StatusMessage},
+		}
+		if s.Parent.IsValid() {
+			o.ParentSpanID = s.Parent.String()
+		}
+		flatten("", s.Attrs, func(k string, v slog.Value) {
+			o.Attributes = append(o.Attributes, otlpAttr(k, v))
+		})
+		ss[i] = o
+	}
+	req := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpKeyValue{otlpAttr("service.name", slog.StringValue(e.ServiceName))}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "github.com/goradd/serve"}, Spans: ss}},
+	}}}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	for k, v := range e.Headers {
+		r.Header.Set(k, v)
+	}
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the collector answered with status %s", resp.Status)
+	}
+	return nil
+}
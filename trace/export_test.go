@@ -0,0 +1,93 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSpan() SpanData {
+	sc, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	start := time.Unix(1700000000, 0)
+	return SpanData{
+		Name:        "GET /item/{id}",
+		SpanContext: sc,
+		Parent:      SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		Kind:        SpanKindServer,
+		Start:       start,
+		End:         start.Add(25 * time.Millisecond),
+		Attrs: []slog.Attr{
+			slog.String("http.route", "/item/{id}"),
+			slog.Int("http.response.status_code", 500),
+			slog.Group("db", slog.Bool("cached", true)),
+		},
+		Status:        StatusError,
+		StatusMessage: "500 Internal Server Error",
+	}
+}
+
+func TestJSONExporter(t *testing.T) {
+	var b strings.Builder
+	require.NoError(t, NewJSONExporter(&b).Export(context.Background(), []SpanData{testSpan()}))
+
+	var m map[string]any
+	require.NoError(t, json.Unmarshal([]byte(b.String()), &m))
+	assert.Equal(t, "GET /item/{id}", m["name"])
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", m["trace_id"])
+	assert.Equal(t, "0102030405060708", m["parent_id"])
+	assert.Equal(t, "server", m["kind"])
+	assert.Equal(t, float64(25*time.Millisecond), m["duration_ns"])
+	assert.Equal(t, "error", m["status"])
+	assert.Equal(t, map[string]any{
+		"http.route":                "/item/{id}",
+		"http.response.status_code": float64(500),
+		"db.cached":                 true,
+	}, m["attributes"])
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body []byte
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header
+	}))
+	defer srv.Close()
+
+	e := NewOTLPExporter(srv.URL+"/v1/traces", "shop")
+	e.Headers = map[string]string{"Authorization": "Bearer x"}
+	require.NoError(t, e.Export(context.Background(), []SpanData{testSpan()}))
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+	assert.Equal(t, "Bearer x", header.Get("Authorization"))
+
+	var req otlpRequest
+	require.NoError(t, json.Unmarshal(body, &req))
+	require.Len(t, req.ResourceSpans, 1)
+	rs := req.ResourceSpans[0]
+	assert.Equal(t, "service.name", rs.Resource.Attributes[0].Key)
+	assert.Equal(t, "shop", *rs.Resource.Attributes[0].Value.StringValue)
+	s := rs.ScopeSpans[0].Spans[0]
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", s.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", s.SpanID)
+	assert.Equal(t, "0102030405060708", s.ParentSpanID)
+	assert.Equal(t, 2, s.Kind)
+	assert.Equal(t, "1700000000000000000", s.StartTimeUnixNano)
+	assert.Equal(t, "1700000000025000000", s.EndTimeUnixNano)
+	assert.Equal(t, 2, s.Status.Code)
+	assert.Equal(t, "500", *s.Attributes[1].Value.IntValue)
+	assert.Equal(t, "db.cached", s.Attributes[2].Key)
+
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	assert.Error(t, e.Export(context.Background(), []SpanData{testSpan()}))
+}
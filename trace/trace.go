@@ -0,0 +1,288 @@
+// Package trace records the time spent serving requests as spans of a distributed trace.
+//
+// The framework starts spans for each stage of the handler stack and for the work of the session manager.
+// Traces are continued from, and passed on to, other services with the W3C Trace Context headers,
+// traceparent and tracestate. See Extract, Inject and Transport.
+//
+// Nothing is recorded until a Tracer is set with SetTracer. The BatchTracer of this package sends spans to an
+// Exporter, like the JSONExporter or the OTLPExporter. You can also adapt another tracing library to the
+// Tracer interface.
+package trace
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+const logModule = "trace"
+
+// TraceID identifies a trace, which is the tree of spans made while serving one request across services.
+type TraceID [16]byte
+
+// IsValid returns true if the ID is not all zeros.
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+// String returns the ID in lowercase hex.
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// IsValid returns true if the ID is not all zeros.
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// String returns the ID in lowercase hex.
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+func newTraceID() (t TraceID) {
+	for !t.IsValid() {
+		for i := 0; i < len(t); i += 8 {
+			putUint64(t[i:], rand.Uint64())
+		}
+	}
+	return
+}
+
+func newSpanID() (s SpanID) {
+	for !s.IsValid() {
+		putUint64(s[:], rand.Uint64())
+	}
+	return
+}
+
+func putUint64(b []byte, v uint64) {
+	for i := range 8 {
+		b[i] = byte(v >> (56 - 8*i))
+	}
+}
+
+// SpanContext is the part of a span that is passed on to other services, and to the child spans of the span.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// Sampled is true if the span is being recorded. Spans follow the decision of their parent.
+	Sampled bool
+	// TraceState is the vendor specific data of the tracestate header, which is passed on unchanged.
+	TraceState string
+	// Remote is true if the span context came from another service.
+	Remote bool
+}
+
+// IsValid returns true if the span context has a trace ID and a span ID.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent returns the span context in the format of the traceparent header.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ErrInvalidTraceparent is returned by ParseTraceparent when the header is not in the W3C Trace Context format.
+var ErrInvalidTraceparent = errors.New("invalid traceparent header")
+
+// ParseTraceparent parses the value of a traceparent header.
+//
+// Headers of later versions of the format are accepted as long as they start with the fields of version 00.
+func ParseTraceparent(s string) (sc SpanContext, err error) {
+	// version-traceid-spanid-flags, as in 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return sc, ErrInvalidTraceparent
+	}
+	version := s[:2]
+	if !isLowerHex(version) || version == "ff" ||
+		(version == "00" && len(s) != 55) ||
+		(len(s) > 55 && s[55] != '-') {
+		return sc, ErrInvalidTraceparent
+	}
+	flags := s[53:55]
+	if !isLowerHex(s[3:35]) || !isLowerHex(s[36:52]) || !isLowerHex(flags) {
+		return sc, ErrInvalidTraceparent
+	}
+	_, _ = hex.Decode(sc.TraceID[:], []byte(s[3:35]))
+	_, _ = hex.Decode(sc.SpanID[:], []byte(s[36:52]))
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	var f [1]byte
+	_, _ = hex.Decode(f[:], []byte(flags))
+	sc.Sampled = f[0]&1 == 1
+	sc.Remote = true
+	return sc, nil
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// maxTraceStateLength is the length of the tracestate header that a service must be able to pass on.
+// Longer headers are dropped rather than truncated, since the entries cannot be told apart safely.
+const maxTraceStateLength = 512
+
+// Extract returns the span context sent by another service in the traceparent and tracestate headers of h.
+// The second result is false if there is no valid traceparent header.
+func Extract(h http.Header) (SpanContext, bool) {
+	sc, err := ParseTraceparent(strings.TrimSpace(h.Get("traceparent")))
+	if err != nil {
+		return SpanContext{}, false
+	}
+	if ts := strings.Join(h.Values("tracestate"), ","); len(ts) <= maxTraceStateLength {
+		sc.TraceState = ts
+	}
+	return sc, true
+}
+
+// Inject puts the span context of the current span of ctx into the traceparent and tracestate headers of h,
+// so that the service receiving the request can continue the trace.
+func Inject(ctx context.Context, h http.Header) {
+	sc := SpanFromContext(ctx).SpanContext()
+	if !sc.IsValid() {
+		return
+	}
+	h.Set("traceparent", sc.Traceparent())
+	if sc.TraceState != "" {
+		h.Set("tracestate", sc.TraceState)
+	} else {
+		h.Del("tracestate")
+	}
+}
+
+// SpanKind describes the relationship of a span to the other spans of a trace.
+type SpanKind int
+
+const (
+	// SpanKindInternal is a span of work done within the service.
+	SpanKindInternal SpanKind = iota + 1
+	// SpanKindServer is the span of a request received by the service.
+	SpanKindServer
+	// SpanKindClient is the span of a request made by the service to another service.
+	SpanKindClient
+)
+
+// String returns the name of the kind.
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	}
+	return "internal"
+}
+
+// Span is a timed piece of work within a trace. End must be called when the work is done.
+type Span interface {
+	// SpanContext returns the identity of the span.
+	SpanContext() SpanContext
+	// IsRecording returns false if the span will not be exported, so that the work of
+	// describing it can be skipped.
+	IsRecording() bool
+	// SetAttributes adds attributes that describe the span.
+	SetAttributes(attrs ...slog.Attr)
+	// SetError marks the span as failed, giving the error as the reason.
+	SetError(err error)
+	// End records the end time of the span. Calls after the first are ignored.
+	End()
+}
+
+// Tracer starts spans.
+type Tracer interface {
+	// Start starts a span that is a child of the current span of ctx, if any. It returns a copy of ctx
+	// that holds the new span as its current span.
+	Start(ctx context.Context, name string, kind SpanKind) (context.Context, Span)
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpan returns a copy of ctx that holds span as its current span.
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+// ContextWithRemoteParent returns a copy of ctx whose current span is the span of another service described by sc,
+// so that the next span started will continue the trace of that service.
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return ContextWithSpan(ctx, nonRecordingSpan{sc})
+}
+
+// SpanFromContext returns the current span of ctx. If there is none, it returns a span that does nothing.
+func SpanFromContext(ctx context.Context) Span {
+	if s, ok := ctx.Value(spanContextKey{}).(Span); ok {
+		return s
+	}
+	return nonRecordingSpan{}
+}
+
+// nonRecordingSpan is a span that carries a span context, but records nothing.
+type nonRecordingSpan struct {
+	sc SpanContext
+}
+
+func (s nonRecordingSpan) SpanContext() SpanContext   { return s.sc }
+func (s nonRecordingSpan) IsRecording() bool          { return false }
+func (s nonRecordingSpan) SetAttributes(...slog.Attr) {}
+func (s nonRecordingSpan) SetError(error)             {}
+func (s nonRecordingSpan) End()                       {}
+
+// noopTracer is the tracer used until SetTracer is called. It passes on the span context of the parent,
+// so that a trace coming from another service is not broken.
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, _ string, _ SpanKind) (context.Context, Span) {
+	return ctx, SpanFromContext(ctx)
+}
+
+type tracerHolder struct {
+	Tracer
+}
+
+var current atomic.Pointer[tracerHolder]
+
+// SetTracer sets the tracer used by the framework. Pass nil to stop tracing.
+//
+// Call it before ServerBase.MakeHandler, since the handler stack only has spans for its stages if tracing
+// is on when the stack is made.
+func SetTracer(t Tracer) {
+	if t == nil {
+		current.Store(nil)
+		return
+	}
+	current.Store(&tracerHolder{t})
+}
+
+// Enabled returns true if a tracer has been set.
+func Enabled() bool {
+	return current.Load() != nil
+}
+
+// Start starts a span with the tracer set by SetTracer. See Tracer.
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, Span) {
+	if t := current.Load(); t != nil {
+		return t.Start(ctx, name, kind)
+	}
+	return noopTracer{}.Start(ctx, name, kind)
+}
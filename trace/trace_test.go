@@ -0,0 +1,147 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memExporter keeps the spans it is given.
+type memExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (e *memExporter) Export(_ context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		sampled bool
+		ok      bool
+	}{
+		{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", false, true},
+		{"later version", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-09-extra", true, true},
+		{"version 00 too long", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"version ff", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"uppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"zero trace", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"zero span", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"short", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false, false},
+		{"empty", "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.in)
+			if !tt.ok {
+				assert.ErrorIs(t, err, ErrInvalidTraceparent)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+			assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+			assert.Equal(t, tt.sampled, sc.Sampled)
+			assert.True(t, sc.Remote)
+		})
+	}
+}
+
+func TestExtractInject(t *testing.T) {
+	h := http.Header{}
+	h.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.Add("tracestate", "a=1")
+	h.Add("tracestate", "b=2")
+	sc, ok := Extract(h)
+	require.True(t, ok)
+	assert.Equal(t, "a=1,b=2", sc.TraceState)
+
+	ctx := ContextWithRemoteParent(context.Background(), sc)
+	out := http.Header{}
+	Inject(ctx, out)
+	assert.Equal(t, h.Get("traceparent"), out.Get("traceparent"))
+	assert.Equal(t, "a=1,b=2", out.Get("tracestate"))
+
+	_, ok = Extract(http.Header{})
+	assert.False(t, ok)
+	out = http.Header{}
+	Inject(context.Background(), out)
+	assert.Empty(t, out)
+}
+
+func TestBatchTracer(t *testing.T) {
+	exp := new(memExporter)
+	tr := NewBatchTracer(exp, 1)
+
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := ContextWithRemoteParent(context.Background(), parent)
+	ctx, server := tr.Start(ctx, "GET /", SpanKindServer)
+	_, child := tr.Start(ctx, "work", SpanKindInternal)
+	child.SetError(errors.New("failed"))
+	child.End()
+	server.End()
+	server.End()
+
+	// A new trace that is not sampled is not recorded, but still has IDs to pass on
+	unsampled := NewBatchTracer(exp, 0)
+	_, s := unsampled.Start(context.Background(), "skipped", SpanKindServer)
+	assert.False(t, s.IsRecording())
+	assert.True(t, s.SpanContext().IsValid())
+	s.End()
+
+	require.NoError(t, tr.Shutdown(context.Background()))
+	require.NoError(t, unsampled.Shutdown(context.Background()))
+	require.Len(t, exp.spans, 2)
+	w, g := exp.spans[0], exp.spans[1]
+	assert.Equal(t, "work", w.Name)
+	assert.Equal(t, StatusError, w.Status)
+	assert.Equal(t, "failed", w.StatusMessage)
+	assert.Equal(t, g.SpanContext.SpanID, w.Parent)
+	assert.Equal(t, parent.TraceID, g.SpanContext.TraceID, "the trace of the caller is continued")
+	assert.Equal(t, parent.SpanID, g.Parent)
+	assert.Equal(t, SpanKindServer, g.Kind)
+
+	_, late := tr.Start(context.Background(), "late", SpanKindInternal)
+	late.End()
+	assert.Equal(t, uint64(1), tr.Dropped(), "spans ending after shutdown are dropped")
+}
+
+func TestTransport(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("traceparent")
+	}))
+	defer srv.Close()
+
+	exp := new(memExporter)
+	tr := NewBatchTracer(exp, 1)
+	SetTracer(tr)
+	defer SetTracer(nil)
+
+	ctx, s := Start(context.Background(), "parent", SpanKindInternal)
+	r, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	resp, err := (&http.Client{Transport: Transport(nil)}).Do(r)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	s.End()
+	assert.Empty(t, r.Header.Get("traceparent"), "the request given to the transport is not changed")
+
+	require.NoError(t, tr.Shutdown(context.Background()))
+	require.Len(t, exp.spans, 2)
+	client := exp.spans[0]
+	assert.Equal(t, SpanKindClient, client.Kind)
+	assert.Equal(t, client.SpanContext.Traceparent(), got)
+	assert.Equal(t, s.SpanContext().SpanID, client.Parent)
+}
@@ -0,0 +1,276 @@
+package trace
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goradd/serve/log"
+)
+
+// Status is the outcome of the work of a span.
+type Status int
+
+const (
+	// StatusUnset means that the span did not report an outcome, which is taken to mean it succeeded.
+	StatusUnset Status = iota
+	// StatusOK means that the span succeeded.
+	StatusOK
+	// StatusError means that the span failed.
+	StatusError
+)
+
+// SpanData is a finished span, as given to an Exporter.
+type SpanData struct {
+	Name          string
+	SpanContext   SpanContext
+	Parent        SpanID
+	Kind          SpanKind
+	Start         time.Time
+	End           time.Time
+	Attrs         []slog.Attr
+	Status        Status
+	StatusMessage string
+}
+
+// Exporter sends finished spans somewhere they can be looked at.
+type Exporter interface {
+	// Export sends a batch of spans. It is called from one goroutine at a time.
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+const (
+	batchSize     = 512
+	queueSize     = 4 * batchSize
+	batchInterval = 5 * time.Second
+)
+
+// BatchTracer is a Tracer that records spans and sends them to an Exporter in batches from a background goroutine.
+//
+// If spans are finished faster than the exporter can send them, the spans that do not fit in the queue are
+// dropped, rather than slowing down the requests being served.
+type BatchTracer struct {
+	exporter    Exporter
+	sampleRatio float64
+
+	mu      sync.RWMutex // guards closing the queue
+	closed  bool
+	queue   chan SpanData
+	done    chan struct{}
+	dropped atomic.Uint64
+}
+
+// NewBatchTracer returns a BatchTracer that sends its spans to exporter, and starts its goroutine.
+//
+// sampleRatio is the fraction of new traces that are recorded, from 0 to 1. Traces continued from another
+// service follow the sampling decision of that service. Call Shutdown to send the last spans and stop the goroutine.
+func NewBatchTracer(exporter Exporter, sampleRatio float64) *BatchTracer {
+	t := &BatchTracer{
+		exporter:    exporter,
+		sampleRatio: sampleRatio,
+		queue:       make(chan SpanData, queueSize),
+		done:        make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start starts a span. See Tracer.
+func (t *BatchTracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, Span) {
+	parent := SpanFromContext(ctx).SpanContext()
+	sc := SpanContext{SpanID: newSpanID()}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+		sc.TraceState = parent.TraceState
+	} else {
+		sc.TraceID = newTraceID()
+		sc.Sampled = t.sampleRatio >= 1 || rand.Float64() < t.sampleRatio
+	}
+	if !sc.Sampled {
+		s := nonRecordingSpan{sc}
+		return ContextWithSpan(ctx, s), s
+	}
+	s := &span{
+		tracer: t,
+		data: SpanData{
+			Name:        name,
+			SpanContext: sc,
+			Parent:      parent.SpanID,
+			Kind:        kind,
+			Start:       time.Now(),
+		},
+	}
+	return ContextWithSpan(ctx, s), s
+}
+
+// Dropped returns the number of spans that were dropped because the queue was full.
+func (t *BatchTracer) Dropped() uint64 {
+	return t.dropped.Load()
+}
+
+// Shutdown sends the spans that are waiting in the queue and stops the goroutine of the tracer.
+// Spans that end after Shutdown is called are dropped.
+func (t *BatchTracer) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *BatchTracer) enqueue(d SpanData) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		t.dropped.Add(1)
+		return
+	}
+	select {
+	case t.queue <- d:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+func (t *BatchTracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+	batch := make([]SpanData, 0, batchSize)
+	for {
+		select {
+		case d, ok := <-t.queue:
+			if !ok {
+				t.export(batch)
+				return
+			}
+			batch = append(batch, d)
+			if len(batch) >= batchSize {
+				t.export(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			t.export(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (t *BatchTracer) export(batch []SpanData) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := t.exporter.Export(ctx, batch); err != nil {
+		log.Warn(nil, logModule, "Could not export spans",
+			slog.Int("count", len(batch)),
+			slog.Any("error", err))
+	}
+}
+
+// span is a span recorded by a BatchTracer.
+type span struct {
+	tracer *BatchTracer
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+func (s *span) SpanContext() SpanContext {
+	return s.data.SpanContext
+}
+
+func (s *span) IsRecording() bool {
+	return true
+}
+
+func (s *span) SetAttributes(attrs ...slog.Attr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Attrs = append(s.data.Attrs, attrs...)
+	}
+}
+
+func (s *span) SetError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended && err != nil {
+		s.data.Status = StatusError
+		s.data.StatusMessage = err.Error()
+	}
+}
+
+func (s *span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	d := s.data
+	s.mu.Unlock()
+	s.tracer.enqueue(d)
+}
+
+// Transport returns an http.RoundTripper that makes a client span for each request sent through base, and
+// passes the trace on to the service receiving the request in the traceparent and tracestate headers.
+// If base is nil, http.DefaultTransport is used.
+//
+// Use it in the http.Client that calls other services, and pass the context of the request being served to
+// http.NewRequestWithContext.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return roundTripper{base}
+}
+
+type roundTripper struct {
+	base http.RoundTripper
+}
+
+func (rt roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx, s := Start(r.Context(), "HTTP "+r.Method, SpanKindClient)
+	defer s.End()
+	if s.IsRecording() {
+		s.SetAttributes(
+			slog.String("http.request.method", r.Method),
+			slog.String("url.full", r.URL.Redacted()),
+			slog.String("server.address", r.URL.Host))
+	}
+	// A RoundTripper must not change the request it was given
+	r = r.Clone(ctx)
+	Inject(ctx, r.Header)
+	resp, err := rt.base.RoundTrip(r)
+	if err != nil {
+		s.SetError(err)
+		return resp, err
+	}
+	s.SetAttributes(slog.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		s.SetError(httpStatusError(resp.StatusCode))
+	}
+	return resp, nil
+}
+
+type httpStatusError int
+
+func (e httpStatusError) Error() string {
+	return "HTTP status " + strconv.Itoa(int(e))
+}
@@ -0,0 +1,95 @@
+package serve
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/goradd/serve/config"
+)
+
+// unixPrefix starts an address that is the path to a unix domain socket.
+const unixPrefix = "unix:"
+
+// listenUnix creates a unix domain socket at path, and sets its mode and owner from the values in config.
+//
+// If a socket file is left over from a previous run that did not shut down cleanly, it is removed.
+// An error is returned if another server is still listening on the socket, or if the path is some other kind of file.
+func listenUnix(path string) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err = setSocketPermissions(path); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// removeStaleSocket removes the socket file at path if nothing is listening on it.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s exists and is not a unix domain socket", path)
+	}
+	c, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		_ = c.Close()
+		return fmt.Errorf("another server is already listening on %s", path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("could not determine if the socket at %s is in use: %w", path, err)
+	}
+	return os.Remove(path)
+}
+
+// setSocketPermissions sets the mode and owner of the socket file at path.
+func setSocketPermissions(path string) error {
+	if err := os.Chmod(path, config.UnixSocketMode); err != nil {
+		return err
+	}
+	if config.UnixSocketUser == "" && config.UnixSocketGroup == "" {
+		return nil
+	}
+	uid, gid := -1, -1 // -1 leaves the value unchanged
+	if config.UnixSocketUser != "" {
+		if _, err := strconv.Atoi(config.UnixSocketUser); err == nil {
+			uid, _ = strconv.Atoi(config.UnixSocketUser)
+		} else if u, err := user.Lookup(config.UnixSocketUser); err != nil {
+			return err
+		} else if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return err
+		}
+	}
+	if config.UnixSocketGroup != "" {
+		if _, err := strconv.Atoi(config.UnixSocketGroup); err == nil {
+			gid, _ = strconv.Atoi(config.UnixSocketGroup)
+		} else if g, err := user.LookupGroup(config.UnixSocketGroup); err != nil {
+			return err
+		} else if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return err
+		}
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// isUnixAddr returns true if addr is the address of a unix domain socket.
+func isUnixAddr(addr string) bool {
+	return strings.HasPrefix(addr, unixPrefix)
+}
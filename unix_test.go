@@ -0,0 +1,51 @@
+//go:build unix
+
+package serve
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goradd/serve/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenUnix(t *testing.T) {
+	// socket paths are limited in length, so avoid the long names of t.TempDir
+	dir, err := os.MkdirTemp("", "serve")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "app.sock")
+
+	config.UnixSocketMode = 0600
+	defer func() { config.UnixSocketMode = 0660 }()
+
+	ln, _, err := listen("unix:" + p)
+	require.NoError(t, err)
+	fi, err := os.Stat(p)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	// A live socket is not replaced
+	_, _, err = listen("unix:" + p)
+	assert.Error(t, err)
+
+	// A stale socket is replaced
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, ln.Close())
+	_, err = os.Stat(p)
+	require.NoError(t, err)
+	ln, _, err = listen("unix:" + p)
+	require.NoError(t, err)
+	require.NoError(t, ln.Close())
+	_, err = os.Stat(p)
+	assert.True(t, os.IsNotExist(err), "closing the listener removes the socket")
+
+	// Other files are not replaced
+	require.NoError(t, os.WriteFile(p, []byte("data"), 0600))
+	_, _, err = listen("unix:" + p)
+	assert.Error(t, err)
+}